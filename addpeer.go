@@ -16,41 +16,59 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
-	"github.com/inhies/go-cjdns/admin"
-	"github.com/inhies/go-cjdns/config"
-	"net"
 	"os"
 	"strings"
 )
 
-func addPassword(data []string) {
-	// Load the config file
-	if File == "" {
-		var cjdnsAdmin *admin.CjdnsAdminConfig
-		var err error
-		if !userSpecifiedCjdnsadmin {
-			cjdnsAdmin, err = loadCjdnsadmin()
-			if err != nil {
-				fmt.Println("Unable to load configuration file:", err)
-				return
-			}
-		} else {
-			cjdnsAdmin, err = readCjdnsadmin(userCjdnsadmin)
-			if err != nil {
-				fmt.Println("Error loading cjdnsadmin file:", err)
-				return
+// Asks which interface and settings block to use, understanding both the
+// older layout with one object per interface and the newer one with arrays.
+// Returns a nil block if there is nothing to choose from.
+func selectInterface(conf map[string]interface{}, prompt string) (string, map[string]interface{}) {
+	names := interfaceNames(conf)
+	if len(names) == 0 {
+		fmt.Println("No valid interfaces found!")
+		return "", nil
+	}
+
+	useIface := names[0]
+	if len(names) > 1 {
+		fmt.Println("You have multiple interfaces to choose from, enter yes or no, or press enter for the default option:")
+	selectIF:
+		for {
+			for _, name := range names {
+				fmt.Printf("%v '%v' [Y/n]: ", prompt, name)
+				if gotYes(true) {
+					useIface = name
+					break selectIF
+				}
 			}
-		}
-		File = cjdnsAdmin.Config
-		if File == "" {
-			fmt.Println("Please specify the configuration file in your .cjdnsadmin file or pass the --file flag.")
-			return
+			fmt.Println("You must select an interface to add to!")
 		}
 	}
+
+	block := selectBlock(conf, useIface, prompt)
+	if block == nil {
+		fmt.Printf("No valid settings for '%v' found!\n", useIface)
+	}
+	return useIface, block
+}
+
+func addPassword(data []string) {
+	// Load the config file
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	unlock, err := lockConfigEdit()
+	if err != nil {
+		fmt.Println("Unable to lock config:", err)
+		return
+	}
+	defer unlock()
+
 	fmt.Printf("Loading configuration from: %v... ", File)
-	conf, err := config.LoadExtConfig(File)
+	conf, err := loadExtConfig(File)
 	if err != nil {
 		fmt.Println("Error loading config:", err)
 		return
@@ -62,8 +80,14 @@ func addPassword(data []string) {
 		fmt.Printf("You didnt supply a password, should I generate one for you? [Y/n]: ")
 		if gotYes(true) {
 			for {
-				input = randString(15, 50)
-				fmt.Printf("Generated: '%v' Accept? [Y/n]: ", input)
+				var bits float64
+				var err error
+				input, bits, err = newPassword()
+				if err != nil {
+					fmt.Println("Unable to generate password:", err)
+					return
+				}
+				fmt.Printf("Generated: '%v' (%.0f bits of entropy) Accept? [Y/n]: ", input, bits)
 				if gotYes(true) {
 					break
 				}
@@ -80,11 +104,15 @@ func addPassword(data []string) {
 		conf["authorizedPasswords"] = make([]interface{}, 0)
 		fmt.Println("Your configuration file does not contain an 'authorizedPasswords' section, so one was created for you")
 	}
-	passwords := conf["authorizedPasswords"].([]interface{})
+	passwords, ok := conf["authorizedPasswords"].([]interface{})
+	if !ok {
+		fmt.Println("The 'authorizedPasswords' section of your configuration file is not a list")
+		return
+	}
 
 	for loc, p := range passwords {
-		x := p.(map[string]interface{})
-		if x["password"] == input {
+		x, ok := p.(map[string]interface{})
+		if ok && x["password"] == input {
 			fmt.Printf("Password '%v' exists with the following information:\n", input)
 			for f, v := range x {
 				fmt.Printf("\t\"%v\":\"%v\"\n", f, v)
@@ -104,59 +132,8 @@ func addPassword(data []string) {
 	pass := make(map[string]interface{})
 	pass["password"] = input
 
-	is := conf["interfaces"].(map[string]interface{})
-	if len(is) == 0 {
-		fmt.Println("No valid interfaces found!")
-		return
-	} else if len(is) > 1 {
-		fmt.Println("You have multiple interfaces to choose from, enter yes or no, or press enter for the default option:")
-	}
-
-	var useIface string
-	var i []interface{}
-
-selectIF:
-	for {
-		for key, _ := range is {
-			if len(is) > 1 {
-				fmt.Printf("Add password to '%v' [Y/n]: ", key)
-				if gotYes(true) {
-					i = is[key].([]interface{})
-					useIface = key
-					break selectIF
-				}
-			} else if len(is) == 1 {
-				i = is[key].([]interface{})
-				useIface = key
-			}
-		}
-		if useIface == "" {
-			fmt.Println("You must select an interface to add to!")
-			continue
-		}
-
-		break
-	}
-	var iX map[string]interface{}
-	if len(i) > 1 {
-		fmt.Printf("You have multiple '%v' options to choose from, enter yes or no, or press enter for the default option\n", useIface)
-	selectIF2:
-		for _, iFace := range i {
-			temp := iFace.(map[string]interface{})
-			fmt.Printf("Add peer to '%v %v' [Y/n]: ", useIface, temp["bind"])
-			if gotYes(true) {
-				iX = iFace.(map[string]interface{})
-				break
-			}
-		}
-		if iX == nil {
-			fmt.Println("You must select an interface to add to!")
-			goto selectIF2
-		}
-	} else if len(i) == 1 {
-		iX = i[0].(map[string]interface{})
-	} else {
-		fmt.Printf("No valid settings for '%v' found!\n", useIface)
+	useIface, iX := selectInterface(conf, "Add password to")
+	if iX == nil {
 		return
 	}
 
@@ -196,88 +173,71 @@ selectIF:
 		return
 	}
 
-	// Get the permissions from the input file
-	stats, err := os.Stat(File)
-	if err != nil {
-		fmt.Println("Error getting permissions for original file:", err)
+	if !writeConfig(conf) {
 		return
 	}
+	fmt.Println("Here are the details to be shared with your new peer:")
+	card := newPeerCard(conf, input)
+	card.addInterface(useIface, iX)
+	printPeerCard(card)
+}
 
-	if File != "" && OutFile == "" {
-		OutFile = File
+// Parses peering details as given on the command line, which are the
+// contents of a connectTo object without the surrounding braces
+func parsePeerDetails(input string) (object map[string]interface{}, err error) {
+	// Surround with {} to make it valid JSON and convert it to an object,
+	// stripping comments just in case
+	err = parseJSON("", []byte("{"+input+"}"), &object)
+	if e, ok := err.(*jsonError); ok {
+		// Point at the input itself rather than the braces added to it
+		return nil, newJSONError("", []byte(input), e.Offset-1, e.Msg)
+	} else if err != nil {
+		return nil, err
 	}
-
-	// Check if the output file exists and prompt befoer overwriting
-	if _, err := os.Stat(OutFile); err == nil {
-		fmt.Printf("Overwrite %v? [y/N]: ", OutFile)
-		if !gotYes(false) {
-			return
+	for key, details := range object {
+		if _, ok := details.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("the details for peer '%v' must be a JSON object", key)
 		}
 	}
+	return
+}
 
-	fmt.Printf("Saving configuration to: %v... ", OutFile)
-	err = config.SaveConfig(OutFile, conf, stats.Mode())
-	if err != nil {
-		fmt.Println("\nError saving config:", err)
-		return
-	}
-	fmt.Printf("Saved\n")
-	var bind string
-	if strings.ToLower(useIface) == "ethinterface" {
-		iFace, err := net.InterfaceByName(iX["bind"].(string))
+func addPeer(data []string) {
+	var object map[string]interface{}
+	var cardPeers map[string]map[string]interface{}
+	if PeerCardFile != "" {
+		card, err := readPeerCard(PeerCardFile)
 		if err != nil {
-			fmt.Println("Unable to get interface's MAC address, you'll have to enter it yourself")
-			bind = "UNKNOWN"
-		} else {
-			bind = iFace.HardwareAddr.String()
+			fmt.Println("Error loading peer card:", err)
+			return
 		}
+		cardPeers = card.connectTo()
 	} else {
-		bind = iX["bind"].(string)
-	}
-	fmt.Println("Here are the details to be shared with your new peer:")
-	fmt.Printf("\"%v\":{\n", bind)
-	fmt.Printf("\t\"password\":\"%v\",\n", pass["password"].(string))
-	fmt.Printf("\t\"publicKey\":\"%v\"\n", conf["publicKey"].(string))
-	fmt.Printf("}\n")
-
-}
-func addPeer(data []string) {
-	if len(data) == 0 {
-		fmt.Println("You must enter the peering details surrounded by single qoutes '<peer details>'")
-		return
+		if len(data) == 0 {
+			fmt.Println("You must enter the peering details surrounded by single qoutes '<peer details>'")
+			return
+		}
+		var err error
+		if object, err = parsePeerDetails(data[0]); err != nil {
+			fmt.Println("JSON Error:", err)
+			return
+		}
 	}
-	input := data[0]
 
-	// Strip comments, just in case, and surround with {} to make it valid JSON
-	raw, err := stripComments([]byte("{" + input + "}"))
-	if err != nil {
-		fmt.Println("Comment errors: ", err)
+	// Load the config file
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
 		return
 	}
-
-	// Convert from JSON to an object
-	var object map[string]interface{}
-	err = json.Unmarshal(raw, &object)
+	unlock, err := lockConfigEdit()
 	if err != nil {
-		fmt.Println("JSON Error:", err)
+		fmt.Println("Unable to lock config:", err)
 		return
 	}
+	defer unlock()
 
-	// Load the config file
-	if File == "" {
-		cjdAdmin, err := loadCjdnsadmin()
-		if err != nil {
-			fmt.Println("Unable to load configuration file:", err)
-			return
-		}
-		File = cjdAdmin.Config
-		if File == "" {
-			fmt.Println("Please specify the configuration file in your .cjdnsadmin file or pass the --file flag.")
-			return
-		}
-	}
 	fmt.Printf("Loading configuration from: %v... ", File)
-	conf, err := config.LoadExtConfig(File)
+	conf, err := loadExtConfig(File)
 	if err != nil {
 		fmt.Println("Error loading config:", err)
 		return
@@ -289,67 +249,36 @@ func addPeer(data []string) {
 		return
 	}
 
-	is := conf["interfaces"].(map[string]interface{})
-	if len(is) == 0 {
-		fmt.Println("No valid interfaces found!")
+	useIface, iX := selectInterface(conf, "Add peer to")
+	if iX == nil {
 		return
-	} else if len(is) > 1 {
-		fmt.Println("You have multiple interfaces to choose from, enter yes or no, or press enter for the default option:")
 	}
 
-	var useIface string
-	var i []interface{}
-
-selectIF:
-	for {
-		for key, _ := range is {
-			if len(is) > 1 {
-				fmt.Printf("Add peer to '%v' [Y/n]: ", key)
-				if gotYes(true) {
-					i = is[key].([]interface{})
-					useIface = key
-					break selectIF
-				}
-			} else if len(is) == 1 {
-				i = is[key].([]interface{})
-				useIface = key
-			}
-		}
-		if useIface == "" {
-			fmt.Println("You must select an interface to add to!")
-			continue
-		}
-		break
-	}
-	var iX map[string]interface{}
-	if len(i) > 1 {
-		fmt.Printf("You have multiple '%v' options to choose from, enter yes or no, or press enter for the default option\n", useIface)
-	selectIF2:
-		for _, iFace := range i {
-			temp := iFace.(map[string]interface{})
-			fmt.Printf("Add peer to '%v %v' [Y/n]: ", useIface, temp["bind"])
-			if gotYes(true) {
-				iX = iFace.(map[string]interface{})
-				break
-			}
-		}
-		if iX == nil {
-			fmt.Println("You must select an interface to add to!")
-			goto selectIF2
+	// Only add the card's addresses for the chosen interface
+	if cardPeers != nil {
+		object = cardPeers[useIface]
+		if len(object) == 0 {
+			fmt.Printf("The peer card has no addresses for '%v'\n", useIface)
+			return
 		}
-	} else if len(i) == 1 {
-		iX = i[0].(map[string]interface{})
-	} else {
-		fmt.Printf("No valid settings for '%v' found!\n", useIface)
-		return
 	}
 
-	peers := iX["connectTo"].(map[string]interface{})
+	peers := connectTo(iX)
+	if peers == nil {
+		peers = make(map[string]interface{})
+		iX["connectTo"] = peers
+	}
 
 	for key, data := range object {
+		details, ok := data.(map[string]interface{})
+		if !ok {
+			fmt.Printf("Skipping peer '%v': its details are not a JSON object\n", key)
+			continue
+		}
+
 		var peer map[string]interface{}
 		if peers[key] != nil {
-			peer = peers[key].(map[string]interface{})
+			peer, _ = peers[key].(map[string]interface{})
 			fmt.Printf("Peer '%v' exists with the following information:\n", key)
 			for f, v := range peer {
 				fmt.Printf("\t\"%v\":\"%v\"\n", f, v)
@@ -357,7 +286,7 @@ selectIF:
 
 			fmt.Printf("Update peer with new information? [Y/n]: ")
 			if gotYes(true) {
-				peer = data.(map[string]interface{})
+				peer = details
 				fmt.Printf("Updating peer '%v'\n", key)
 			} else {
 				fmt.Printf("Skipped updating peer '%v'\n", key)
@@ -365,7 +294,7 @@ selectIF:
 			}
 		} else {
 			fmt.Printf("Adding new peer '%v'\n", key)
-			peer = data.(map[string]interface{})
+			peer = details
 		}
 
 		// Optionally add meta information
@@ -405,30 +334,7 @@ selectIF:
 
 	}
 
-	// Get the permissions from the input file
-	stats, err := os.Stat(File)
-	if err != nil {
-		fmt.Println("Error getting permissions for original file:", err)
-		return
-	}
-
-	if File != "" && OutFile == "" {
-		OutFile = File
-	}
-
-	// Check if the output file exists and prompt befoer overwriting
-	if _, err := os.Stat(OutFile); err == nil {
-		fmt.Printf("Overwrite %v? [y/N]: ", OutFile)
-		if !gotYes(false) {
-			return
-		}
-	}
-
-	fmt.Printf("Saving configuration to: %v... ", OutFile)
-	err = config.SaveConfig(OutFile, conf, stats.Mode())
-	if err != nil {
-		fmt.Println("Error saving config:", err)
-		return
+	if writeConfig(conf) {
+		addPeerHosts(conf)
 	}
-	fmt.Printf("Saved\n")
 }
@@ -0,0 +1,176 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/inhies/go-cjdns/key"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// How many inputs are resolved at the same time
+const batchWorkers = 16
+
+// The result of looking up one input in batch mode
+type batchResult struct {
+	Input    string   `json:"input"`
+	IP       string   `json:"ip,omitempty"`
+	IPs      []string `json:"ips,omitempty"`
+	Hostname string   `json:"hostname,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Returns true if the arguments should be handled in batch mode
+func isBatch(args []string) bool {
+	return JSONOutput || len(BatchFiles) > 0 || len(args) > 1 || (len(args) == 1 && args[0] == "-")
+}
+
+// Collects the inputs from the arguments and the files given with -batch.
+// An argument of "-" reads from stdin, one input per line, as does each
+// file; any other argument is an input itself.
+func readInputs(args []string) (inputs []string, err error) {
+	for _, arg := range args {
+		if arg != "-" {
+			inputs = append(inputs, arg)
+		} else if inputs, err = readInputLines(os.Stdin, inputs); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range BatchFiles {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		inputs, err = readInputLines(f, inputs)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Appends the inputs read from r, one per line. Only the last word of each
+// line is used and quotes and commas around it are removed, so lines copied
+// from a config work too.
+func readInputLines(r io.Reader, inputs []string) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		fields := strings.Fields(line)
+		input := strings.Trim(fields[len(fields)-1], `",`)
+		if input != "" {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs, scanner.Err()
+}
+
+// Looks up every input concurrently and returns the results in the same
+// order as the inputs
+func runBatch(inputs []string, lookup func(input string) *batchResult) []*batchResult {
+	results := make([]*batchResult, len(inputs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < batchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = lookup(inputs[i])
+			}
+		}()
+	}
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// Prints batch results as tab separated columns of input, address,
+// hostname and error, or as JSON if -json was given
+func printBatch(results []*batchResult) {
+	if JSONOutput {
+		out, err := json.MarshalIndent(results, "", "\t")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	for _, r := range results {
+		addr := r.IP
+		if len(r.IPs) > 0 {
+			addr = strings.Join(r.IPs, ",")
+		}
+		fmt.Printf("%v\t%v\t%v\t%v\n", r.Input, addr, r.Hostname, r.Error)
+	}
+}
+
+// Converts a public key to its address and looks up its hostname
+func lookupKey(input string) *batchResult {
+	r := &batchResult{Input: input}
+	if err := validPublicKey(input); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	pub, _ := key.DecodePublic(input)
+	r.IP = padIPv6(pub.IP())
+	if !NoDNS {
+		r.Hostname, _ = resolveIP(r.IP)
+	}
+	return r
+}
+
+// Looks up the hostname for an address or the addresses for a hostname
+func lookupHost(input string) *batchResult {
+	r := &batchResult{Input: input}
+	var err error
+	switch {
+	case validIP(input):
+		r.IP = input
+		r.Hostname, err = resolveIP(input)
+	case validHost(input):
+		r.Hostname = input
+		r.IPs, err = resolveHost(input)
+	default:
+		err = fmt.Errorf("Invalid hostname or IPv6 address")
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Handles the ip and host commands in batch mode
+func batchLookup(args []string, lookup func(input string) *batchResult) {
+	inputs, err := readInputs(args)
+	if err != nil {
+		fmt.Println("Error reading input:", err)
+		return
+	}
+	printBatch(runBatch(inputs, lookup))
+}
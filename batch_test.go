@@ -0,0 +1,77 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsBatch(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"r6jzx210usqbgnm3pdtm1z6btd14pvdtkn5j8qnpgqzknpggkuw0.k"}, false},
+		{[]string{"-"}, true},
+		{[]string{"a", "b"}, true},
+		// A lone argument is never taken as a file name
+		{[]string{"batch_test.go"}, false},
+	}
+	for _, test := range tests {
+		if got := isBatch(test.args); got != test.want {
+			t.Errorf("isBatch(%q) = %v, want %v", test.args, got, test.want)
+		}
+	}
+}
+
+func TestReadInputs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.txt")
+	lines := "# peers\n\n\"publicKey\": \"key1.k\",\n  key2.k  \n// done\n"
+	if err := ioutil.WriteFile(file, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	BatchFiles = stringList{file}
+	defer func() { BatchFiles = nil }()
+
+	got, err := readInputs([]string{"key0.k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"key0.k", "key1.k", "key2.k"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readInputs = %q, want %q", got, want)
+	}
+	if !isBatch(nil) {
+		t.Errorf("isBatch with -batch set = false, want true")
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	NoDNS = true
+	defer func() { NoDNS = false }()
+
+	r := lookupKey("r6jzx210usqbgnm3pdtm1z6btd14pvdtkn5j8qnpgqzknpggkuw0.k")
+	if r.Error != "" || r.IP == "" {
+		t.Errorf("lookupKey(valid key) = %+v", r)
+	}
+	// Decodes, but doesn't give an fc address
+	if r := lookupKey("3204tfjsgcr2ht8j868gzs56dzvlxudd0wn6q2j40tx9k5n2u0h0.k"); r.Error == "" {
+		t.Errorf("lookupKey(non-fc key) = %+v, want an error", r)
+	}
+	if r := lookupKey("nonsense"); r.Error == "" {
+		t.Errorf("lookupKey(nonsense) = %+v, want an error", r)
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/inhies/go-cjdns/key"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Passwords shorter than this are reported as too short
+const minPasswordLength = 12
+
+// Top level settings understood by cjdns
+var knownConfigKeys = map[string]bool{
+	"privateKey":                  true,
+	"publicKey":                   true,
+	"ipv6":                        true,
+	"authorizedPasswords":         true,
+	"admin":                       true,
+	"interfaces":                  true,
+	"router":                      true,
+	"resetAfterInactivitySeconds": true,
+	"pidFile":                     true,
+	"security":                    true,
+	"logging":                     true,
+	"noBackground":                true,
+	"dns":                         true,
+	"version":                     true,
+}
+
+type severity int
+
+const (
+	severityWarning severity = iota
+	severityError
+)
+
+func (s severity) String() string {
+	if s == severityError {
+		return "ERROR"
+	}
+	return "WARNING"
+}
+
+type configProblem struct {
+	Severity severity
+	Msg      string
+}
+
+type configChecker struct {
+	Problems []configProblem
+	Errors   int
+}
+
+func (c *configChecker) warnf(format string, a ...interface{}) {
+	c.Problems = append(c.Problems, configProblem{severityWarning, fmt.Sprintf(format, a...)})
+}
+
+func (c *configChecker) errorf(format string, a ...interface{}) {
+	c.Problems = append(c.Problems, configProblem{severityError, fmt.Sprintf(format, a...)})
+	c.Errors++
+}
+
+// Loads the config file and prints any problems with it. Exits with a
+// non-zero status if any errors were found.
+func checkConfig() {
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Loading configuration from: %v... ", File)
+	conf, err := loadExtConfig(File)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Loaded\n")
+
+	c := &configChecker{}
+	if stats, err := os.Stat(File); err == nil && stats.Mode().Perm()&0004 != 0 {
+		c.errorf("%v is world-readable (mode %v) and exposes your private key", File, stats.Mode().Perm())
+	}
+	c.checkIdentity(conf)
+	c.checkPasswords(conf)
+	c.checkAdmin(conf)
+	c.checkPeers(conf)
+	for _, k := range sortedKeys(conf) {
+		if !knownConfigKeys[k] {
+			c.warnf("Unknown top level setting '%v'", k)
+		}
+	}
+
+	for _, p := range c.Problems {
+		fmt.Printf("%-7v  %v\n", p.Severity, p.Msg)
+	}
+	fmt.Printf("Found %d errors and %d warnings\n", c.Errors, len(c.Problems)-c.Errors)
+	if c.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// Checks that privateKey, publicKey and ipv6 all describe the same node
+func (c *configChecker) checkIdentity(conf map[string]interface{}) {
+	privStr, _ := conf["privateKey"].(string)
+	pubStr, _ := conf["publicKey"].(string)
+	ipStr, _ := conf["ipv6"].(string)
+
+	var pub *key.Public
+	if privStr == "" {
+		c.errorf("No privateKey found")
+	} else if priv, err := decodePrivateKey(privStr); err != nil {
+		c.errorf("privateKey: %v", err)
+	} else if pub, err = privateToPublic(priv); err != nil {
+		c.errorf("privateKey: %v", err)
+	} else if pub.IP()[0] != 0xfc {
+		c.errorf("privateKey does not produce a valid cjdns address")
+	} else if pubStr != "" && pubStr != pub.String() {
+		c.errorf("publicKey does not match privateKey, expected %v", pub.String())
+	}
+
+	if pub == nil && pubStr != "" {
+		var err error
+		if pub, err = key.DecodePublic(pubStr); err != nil {
+			c.errorf("publicKey: %v", err)
+		}
+	}
+
+	if ipStr == "" || pub == nil {
+		return
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		c.errorf("ipv6 '%v' is not a valid IPv6 address", ipStr)
+	} else if expected := pub.IP(); !ip.Equal(expected) {
+		c.errorf("ipv6 does not match the node's keys, expected %v", expected)
+	}
+}
+
+// Checks authorizedPasswords for duplicate and weak passwords
+func (c *configChecker) checkPasswords(conf map[string]interface{}) {
+	passwords, _ := conf["authorizedPasswords"].([]interface{})
+	seen := make(map[string]bool)
+	for i, p := range passwords {
+		entry, _ := p.(map[string]interface{})
+		pass, _ := entry["password"].(string)
+		switch {
+		case pass == "":
+			c.errorf("authorizedPasswords[%d] has no password", i)
+			continue
+		case seen[pass]:
+			c.errorf("authorizedPasswords[%d] duplicates an earlier password", i)
+		case len(pass) < minPasswordLength:
+			c.warnf("authorizedPasswords[%d] is only %d characters long", i, len(pass))
+		case weakPassword(pass):
+			c.warnf("authorizedPasswords[%d] uses only one kind of character", i)
+		}
+		seen[pass] = true
+	}
+}
+
+// Returns true if every character in pass is of the same class
+func weakPassword(pass string) bool {
+	classes := make(map[string]bool)
+	for _, r := range pass {
+		switch {
+		case unicode.IsUpper(r):
+			classes["upper"] = true
+		case unicode.IsLower(r):
+			classes["lower"] = true
+		case unicode.IsDigit(r):
+			classes["digit"] = true
+		default:
+			classes["other"] = true
+		}
+	}
+	return len(classes) < 2
+}
+
+// Checks that the admin interface is only reachable from this machine
+func (c *configChecker) checkAdmin(conf map[string]interface{}) {
+	adm, _ := conf["admin"].(map[string]interface{})
+	bind, _ := adm["bind"].(string)
+	if bind == "" {
+		c.warnf("No admin bind address found, cjdcmd will not be able to connect to cjdns")
+		return
+	}
+	host, _, err := net.SplitHostPort(bind)
+	if err != nil {
+		c.errorf("admin bind '%v' is invalid: %v", bind, err)
+		return
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		c.warnf("admin is bound to non-loopback address %v", bind)
+	}
+}
+
+// Checks every connectTo entry and looks for peers listed more than once
+func (c *configChecker) checkPeers(conf map[string]interface{}) {
+	found := make(map[string]string)
+	for _, name := range interfaceNames(conf) {
+		for n, block := range interfaceBlocks(conf, name) {
+			iface := fmt.Sprintf("%v[%d]", name, n)
+			peers := connectTo(block)
+			for _, addr := range sortedKeys(peers) {
+				peer, _ := peers[addr].(map[string]interface{})
+				where := iface + " peer " + addr
+				if err := validPeerAddress(name, addr); err != nil {
+					c.errorf("%v: %v", where, err)
+				}
+				if pass, _ := peer["password"].(string); pass == "" {
+					c.errorf("%v has no password", where)
+				}
+				pubKey, _ := peer["publicKey"].(string)
+				if err := validPublicKey(pubKey); err != nil {
+					c.errorf("%v: %v", where, err)
+					continue
+				}
+				if other, ok := found[pubKey]; ok {
+					c.warnf("%v has the same publicKey as %v", where, other)
+				} else {
+					found[pubKey] = where
+				}
+			}
+		}
+	}
+}
+
+// Returns an error if s is not a public key for a usable cjdns address
+func validPublicKey(s string) error {
+	if s == "" {
+		return fmt.Errorf("missing publicKey")
+	}
+	pub, err := key.DecodePublic(s)
+	if err != nil {
+		return fmt.Errorf("invalid publicKey '%v': %v", s, err)
+	}
+	if pub.IP()[0] != 0xfc {
+		return fmt.Errorf("publicKey '%v' does not produce a valid cjdns address", s)
+	}
+	return nil
+}
+
+// Returns an error if addr is not a valid connectTo address for the
+// interface type
+func validPeerAddress(iface, addr string) error {
+	switch strings.ToLower(iface) {
+	case "udpinterface":
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("'%v' is not an IP address", host)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("'%v' is not a valid port", port)
+		}
+	case "ethinterface":
+		if _, err := net.ParseMAC(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
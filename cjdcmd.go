@@ -14,17 +14,12 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/inhies/go-cjdns/admin"
-	"github.com/inhies/go-cjdns/config"
 	"github.com/inhies/go-cjdns/key"
-	"io/ioutil"
-	"math/rand"
 	"os"
 	"os/signal"
-	"os/user"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -42,7 +37,6 @@ const (
 	defaultPingInterval = float64(1)
 
 	defaultLogLevel    = "DEBUG"
-	defaultLogFile     = ""
 	defaultLogFileLine = -1
 
 	defaultPass      = ""
@@ -50,6 +44,10 @@ const (
 
 	defaultNoDNS = false
 
+	defaultConfigBackups = 5
+
+	defaultPassAlphabet = "alnum"
+
 	pingCmd       = "ping"
 	logCmd        = "log"
 	traceCmd      = "traceroute"
@@ -67,6 +65,20 @@ const (
 	addPassCmd    = "addpass"
 	memoryCmd     = "memory"
 	cjdnsadminCmd = "cjdnsadmin"
+	checkCfgCmd   = "checkconfig"
+	confDiffCmd   = "confdiff"
+	restoreCfgCmd = "restoreconfig"
+	configCmd     = "config"
+	peerCardCmd   = "peercard"
+	importCmd     = "importpeers"
+	genKeyCmd     = "genkey"
+	whoamiCmd     = "whoami"
+	genConfCmd    = "genconf"
+	migrateCmd    = "migrateconfig"
+	whereisCmd    = "whereis"
+	logReplayCmd  = "logreplay"
+	dnsCacheCmd   = "dnscache"
+	hostsCmd      = "hosts"
 )
 
 var (
@@ -75,8 +87,21 @@ var (
 	PingInterval float64
 
 	LogLevel    string
-	LogFile     string
+	LogFiles    stringList
 	LogFileLine int
+	LogFormat   string
+	LogFollow   bool
+
+	LogRecordDir  string
+	LogRecordSize int
+	LogRecordAge  time.Duration
+
+	LogSyslog string
+
+	LogSummary    time.Duration
+	LogSummaryTop int
+
+	LogInclude, LogExclude, LogIPs, LogHighlight stringList
 
 	fs *flag.FlagSet
 
@@ -87,8 +112,51 @@ var (
 
 	NoDNS bool
 
+	Resolvers       stringList
+	ResolverMode    string
+	ResolverTimeout time.Duration
+	DNSWorkers      int
+	DNSDeadline     time.Duration
+
+	HostsForce bool
+
+	ConfigBackups int
+
+	CardName, CardContact, PeerCardFile string
+
+	ImportRegion  string
+	ImportMax     int
+	ImportResolve bool
+	ImportPing    bool
+
+	PassCount    int
+	PassLength   int
+	PassAlphabet string
+	PassWordList string
+
+	Vanity string
+
+	PrivateKey string
+
+	JSONOutput bool
+	BatchFiles stringList
+
+	GenConfTemplate string
+	GenConfPort     int
+	GenConfEth      string
+	GenConfPeers    string
+	GenConfAdmin    bool
+
+	MigrateTo int
+
+	ConfigString bool
+
 	userSpecifiedCjdnsadmin bool
 	userCjdnsadmin          string
+	userSpecifiedFile       bool
+
+	Node       string
+	AddProfile string
 )
 
 type Route struct {
@@ -114,17 +182,73 @@ func init() {
 		usagePingInterval = "[ping] specify the delay between successive pings"
 
 		usageLogLevel    = "[log] specify the logging level to use"
-		usageLogFile     = "[log] specify the cjdns source file you wish to see log output from"
+		usageLogFile     = "[log] specify the cjdns source file you wish to see log output from, may be repeated"
 		usageLogFileLine = "[log] specify the cjdns source file line to log"
+		usageLogFormat   = "[log] short, full, json, logfmt or a Go template using .Counter .Time .Level .File .Line .Message"
+		usageLogInclude  = "[log] only show messages matching this regular expression, may be repeated"
+		usageLogExclude  = "[log] hide messages matching this regular expression, may be repeated"
+		usageLogIP       = "[log] only show messages mentioning this IPv6 address, may be repeated"
+		usageHighlight   = "[log] colour text matching this regular expression, may be repeated"
+		usageLogFollow   = "[log] keep reconnecting to cjdns if the connection is lost, such as when it restarts"
+		usageRecord      = "[log] also save every message as ndjson in this directory"
+		usageRecordSize  = "[log] start a new recording file after this many megabytes"
+		usageRecordAge   = "[log] start a new recording file after this long"
+		usageSyslog      = "[log] forward messages to syslog at udp://host:514, tcp://host:514 or unix:///dev/log, implies -follow"
+		usageSummary     = "[log] collect messages for this long, such as 60s, then print counts instead of the messages"
+		usageSummaryTop  = "[log][logreplay] how many of the most common sources and messages to show in a summary"
 
 		usageFile    = "[all] the cjdroute.conf configuration file to use, edit, or view"
 		usageOutFile = "[all] the cjdroute.conf configuration file to save to"
 
-		usagePass = "[all] specify the admin password"
+		usagePass      = "[all] specify the admin password"
+		usageAdminAddr = "[all] specify the admin address and port, such as 127.0.0.1:11234"
 
 		usageNoDNS = "[all] Do not perform DNS lookups (greatly improves speed)"
 
-		usageCjdnsadmin = "[all] Specify the cjdnsadmin file to use"
+		usageResolver        = "[all] a DNS server to resolve cjdns names with, such as fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535. May be repeated"
+		usageResolverMode    = "[all] resolve names with the system resolver, the mesh resolvers, or both"
+		usageResolverTimeout = "[all] how long to wait for each DNS server to answer"
+		usageDNSWorkers      = "[dump][peers][traceroute] how many hostnames to look up at once"
+		usageDNSDeadline     = "[dump][peers][traceroute] how long to spend looking up hostnames before showing the addresses of the rest"
+
+		usageHostsForce = "[hosts] let import replace names that are already in the hosts file"
+
+		usageCjdnsadmin = "[all] Specify the cjdnsadmin file to use, [genconf] the one -write-cjdnsadmin writes"
+		usageNode       = "[all] use this profile from your cjdnsadmin file instead of the default one"
+		usageAddProfile = "[cjdnsadmin][genconf] add the node to the cjdnsadmin file as a profile with this name"
+
+		usageConfigBackups = "[addpeer][addpass][cleanconfig] number of backups of the config file to keep"
+
+		usageCardName    = "[peercard][addpass] your node's name to include in peer cards"
+		usageCardContact = "[peercard][addpass] contact details to include in peer cards"
+		usageCard        = "[addpeer] add the peer described by a peer card file, or - for stdin"
+
+		usageImportRegion  = "[importpeers] only import peers from this region directory"
+		usageImportMax     = "[importpeers] the maximum number of peers to add, 0 for no limit"
+		usageImportResolve = "[importpeers] resolve peers given by hostname to their IP address"
+		usageImportPing    = "[importpeers] only add peers which answer a cjdns ping"
+
+		usagePassCount    = "[passgen] the number of passwords to generate"
+		usagePassLength   = "[passgen][addpass] password length in characters, or in words for the words alphabet"
+		usagePassAlphabet = "[passgen][addpass] characters to use for passwords: alnum, base32 or words"
+		usagePassWordList = "[passgen][addpass] file of words, such as a diceware list, for the words alphabet"
+
+		usageVanity = "[genkey] search for keys whose IPv6 address starts with this prefix, such as fc12:34"
+
+		usagePrivateKey = "[ip] derive the public key and IPv6 address from this hex private key"
+
+		usageJSON  = "[ip][host] print the results as JSON"
+		usageBatch = "[ip][host] read inputs from this file, one per line, may be repeated"
+
+		usageGenConfTemplate = "[genconf] the kind of node to configure: server, client or eth-only"
+		usageGenConfPort     = "[genconf] the UDP port to listen on, random if not given"
+		usageGenConfEth      = "[genconf] also peer over this network card, such as eth0"
+		usageGenConfPeers    = "[genconf] comma separated peer files to connect to"
+		usageGenConfAdmin    = "[genconf] also write a matching .cjdnsadmin, or profile with -add-profile"
+
+		usageMigrateTo = "[migrateconfig] the config layout to convert to: 0 for one object per interface type, 1 for interface arrays, 2 for tunDevice and ipTunnel under router, 3 for security objects"
+
+		usageConfigString = "[config] set the value as a string exactly as given instead of parsing it as JSON"
 	)
 
 	fs.StringVar(&File, "file", "", usageFile)
@@ -145,15 +269,70 @@ func init() {
 	fs.StringVar(&LogLevel, "level", defaultLogLevel, usageLogLevel)
 	fs.StringVar(&LogLevel, "l", defaultLogLevel, usageLogLevel+" (shorthand)")
 
-	fs.StringVar(&LogFile, "logfile", defaultLogFile, usageLogFile)
+	fs.Var(&LogFiles, "logfile", usageLogFile)
 	fs.IntVar(&LogFileLine, "line", defaultLogFileLine, usageLogFileLine)
+	fs.StringVar(&LogFormat, "log-format", defaultLogFormat, usageLogFormat)
+	fs.Var(&LogInclude, "include", usageLogInclude)
+	fs.Var(&LogExclude, "exclude", usageLogExclude)
+	fs.Var(&LogIPs, "ip", usageLogIP)
+	fs.Var(&LogHighlight, "highlight", usageHighlight)
+	fs.BoolVar(&LogFollow, "follow", false, usageLogFollow)
+	fs.StringVar(&LogRecordDir, "record", "", usageRecord)
+	fs.IntVar(&LogRecordSize, "record-size", defaultRecordSize, usageRecordSize)
+	fs.DurationVar(&LogRecordAge, "record-age", defaultRecordAge, usageRecordAge)
+	fs.StringVar(&LogSyslog, "syslog", "", usageSyslog)
+	fs.DurationVar(&LogSummary, "summary", 0, usageSummary)
+	fs.IntVar(&LogSummaryTop, "top", defaultSummaryTop, usageSummaryTop)
 
 	fs.BoolVar(&NoDNS, "nodns", defaultNoDNS, usageNoDNS)
 
+	fs.Var(&Resolvers, "resolver", usageResolver)
+	fs.StringVar(&ResolverMode, "resolver-mode", defaultResolverMode, usageResolverMode)
+	fs.DurationVar(&ResolverTimeout, "resolver-timeout", defaultResolverTimeout, usageResolverTimeout)
+	fs.IntVar(&DNSWorkers, "dns-workers", defaultDNSWorkers, usageDNSWorkers)
+	fs.DurationVar(&DNSDeadline, "dns-deadline", defaultDNSDeadline, usageDNSDeadline)
+
+	fs.BoolVar(&HostsForce, "force", false, usageHostsForce)
+
+	fs.StringVar(&AdminBind, "admin-addr", defaultAdminBind, usageAdminAddr)
+	fs.StringVar(&AdminPassword, "admin-pass", defaultPass, usagePass)
+
 	fs.StringVar(&userCjdnsadmin, "cjdnsadmin", "", usageCjdnsadmin)
+	fs.StringVar(&Node, "node", "", usageNode)
+	fs.StringVar(&AddProfile, "add-profile", "", usageAddProfile)
+
+	fs.IntVar(&ConfigBackups, "backups", defaultConfigBackups, usageConfigBackups)
+
+	fs.StringVar(&CardName, "name", "", usageCardName)
+	fs.StringVar(&CardContact, "contact", "", usageCardContact)
+	fs.StringVar(&PeerCardFile, "card", "", usageCard)
 
-	// Seed the PRG
-	rand.Seed(time.Now().UTC().UnixNano())
+	fs.StringVar(&ImportRegion, "region", "", usageImportRegion)
+	fs.IntVar(&ImportMax, "max", 0, usageImportMax)
+	fs.BoolVar(&ImportResolve, "resolve", false, usageImportResolve)
+	fs.BoolVar(&ImportPing, "ping", false, usageImportPing)
+
+	fs.IntVar(&PassCount, "n", 1, usagePassCount)
+	fs.IntVar(&PassLength, "length", 0, usagePassLength)
+	fs.StringVar(&PassAlphabet, "alphabet", defaultPassAlphabet, usagePassAlphabet)
+	fs.StringVar(&PassWordList, "wordlist", "", usagePassWordList)
+
+	fs.StringVar(&Vanity, "vanity", "", usageVanity)
+
+	fs.StringVar(&PrivateKey, "private", "", usagePrivateKey)
+
+	fs.BoolVar(&JSONOutput, "json", false, usageJSON)
+	fs.Var(&BatchFiles, "batch", usageBatch)
+
+	fs.StringVar(&GenConfTemplate, "template", "server", usageGenConfTemplate)
+	fs.IntVar(&GenConfPort, "port", 0, usageGenConfPort)
+	fs.StringVar(&GenConfEth, "eth", "", usageGenConfEth)
+	fs.StringVar(&GenConfPeers, "peers", "", usageGenConfPeers)
+	fs.BoolVar(&GenConfAdmin, "write-cjdnsadmin", false, usageGenConfAdmin)
+
+	fs.IntVar(&MigrateTo, "to", latestConfigVersion, usageMigrateTo)
+
+	fs.BoolVar(&ConfigString, "string", false, usageConfigString)
 }
 
 func main() {
@@ -168,24 +347,23 @@ func main() {
 			fs.PrintDefaults()
 			return
 		}
-	} else {
-		fs.Parse(os.Args[2:])
 	}
+	data := parseArgs(fs, os.Args[2:])
 
 	//TODO(inhies): check argv[0] for trailing commands.
 	//For example, to run ctraceroute:
 	//ln -s /path/to/cjdcmd /usr/bin/ctraceroute like things
 	command := os.Args[1]
 
-	arguments := fs.Args()
-	data := arguments[fs.NFlag()-fs.NFlag():]
-
 	//Setup variables now so that if the program is killed we can still finish what we're doing
 	ping := &Ping{}
 
 	globalData := &Data{&admin.Conn{}, ""}
 	var err error
 	if File != "" {
+		// Remember --file was given, as File is filled in from the admin
+		// details later on when it wasn't
+		userSpecifiedFile = true
 		File, err = filepath.Abs(File)
 		if err != nil {
 			fmt.Println(err)
@@ -206,20 +384,24 @@ func main() {
 		userSpecifiedCjdnsadmin = true
 	}
 
+	if !resolverModes[ResolverMode] {
+		fmt.Printf("Unknown resolver mode '%v', use system, mesh or both\n", ResolverMode)
+		return
+	}
+
+	// Remember any hostnames resolved while running the command
+	defer saveDNSCache()
+
 	// capture ctrl+c (actually any kind of kill signal...)
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
+		// The log command stops itself so it can finish what it's doing
+		if command == logCmd {
+			return
+		}
 		for _ = range c {
 			fmt.Printf("\n")
-			if command == "log" {
-				// Unsubscribe from logging
-				err := globalData.User.AdminLog_unsubscribe(globalData.LoggingStreamID)
-				if err != nil {
-					fmt.Printf("%v\n", err)
-					os.Exit(1)
-				}
-			}
 			if command == "ping" {
 				//stop pinging and print results
 				outputPing(ping)
@@ -229,6 +411,7 @@ func main() {
 			if globalData.User.Conn != nil {
 				globalData.User.Conn.Close()
 			}
+			saveDNSCache()
 
 			// Exit with no error
 			os.Exit(0)
@@ -238,26 +421,9 @@ func main() {
 	switch command {
 	// Generates a .cjdnsadmin file
 	case cjdnsadminCmd:
-		if File == "" {
-			var cjdnsAdmin *admin.CjdnsAdminConfig
-			if !userSpecifiedCjdnsadmin {
-				cjdnsAdmin, err = loadCjdnsadmin()
-				if err != nil {
-					fmt.Println("Unable to load configuration file:", err)
-					return
-				}
-			} else {
-				cjdnsAdmin, err = readCjdnsadmin(userCjdnsadmin)
-				if err != nil {
-					fmt.Println("Error loading cjdnsadmin file:", err)
-					return
-				}
-			}
-			File = cjdnsAdmin.Config
-			if File == "" {
-				fmt.Println("Please specify the configuration file in your .cjdnsadmin file or pass the --file flag.")
-				return
-			}
+		if err := setConfigFile(); err != nil {
+			fmt.Println(err)
+			return
 		}
 
 		fmt.Printf("Loading configuration from: %v... ", File)
@@ -277,66 +443,39 @@ func main() {
 			return
 		}
 
-		adminOut := admin.CjdnsAdminConfig{
+		adminOut := &admin.CjdnsAdminConfig{
 			Addr:     addr,
 			Port:     portInt,
 			Password: conf.Admin.Password,
 			Config:   File,
 		}
-
-		jsonout, err := json.MarshalIndent(adminOut, "", "\t")
-		if err != nil {
-			fmt.Println("Unable to create JSON for .cjdnsadmin")
+		if AddProfile == "" {
+			saveCjdnsadmin(OutFile, adminOut)
 			return
 		}
-
 		if OutFile == "" {
-			tUser, err := user.Current()
-			if err != nil {
-				fmt.Println("I was unable to get your home directory, please manually specify where to save the file with --outfile")
-				return
-			}
-			OutFile = tUser.HomeDir + "/.cjdnsadmin"
-		}
-
-		// Check if the output file exists and prompt befoer overwriting
-		if _, err := os.Stat(OutFile); err == nil {
-			fmt.Printf("Overwrite %v? [y/N]: ", OutFile)
-			if !gotYes(false) {
+			if OutFile, err = homeCjdnsadmin(); err != nil {
+				fmt.Println(err)
 				return
 			}
-		} else {
-			fmt.Println("Saving to", OutFile)
 		}
-
-		ioutil.WriteFile(OutFile, jsonout, 0600)
+		addCjdnsadminProfile(OutFile, AddProfile, adminOut)
 
 	case cleanCfgCmd:
 		// Load the config file
-		if File == "" {
-			var cjdnsAdmin *admin.CjdnsAdminConfig
-			if !userSpecifiedCjdnsadmin {
-				cjdnsAdmin, err = loadCjdnsadmin()
-				if err != nil {
-					fmt.Println("Unable to load configuration file:", err)
-					return
-				}
-			} else {
-				cjdnsAdmin, err = readCjdnsadmin(userCjdnsadmin)
-				if err != nil {
-					fmt.Println("Error loading cjdnsadmin file:", err)
-					return
-				}
-			}
-			File = cjdnsAdmin.Config
-			if File == "" {
-				fmt.Println("Please specify the configuration file in your .cjdnsadmin file or pass the --file flag.")
-				return
-			}
+		if err := setConfigFile(); err != nil {
+			fmt.Println(err)
+			return
 		}
+		unlock, err := lockConfigEdit()
+		if err != nil {
+			fmt.Println("Unable to lock config:", err)
+			return
+		}
+		defer unlock()
 
 		fmt.Printf("Loading configuration from: %v... ", File)
-		conf, err := config.LoadExtConfig(File)
+		conf, err := loadExtConfig(File)
 		if err != nil {
 			fmt.Println("Error loading config:", err)
 			return
@@ -363,12 +502,32 @@ func main() {
 		}
 
 		fmt.Printf("Saving configuration to: %v... ", OutFile)
-		err = config.SaveConfig(OutFile, conf, stats.Mode())
+		err = saveConfig(OutFile, conf, stats.Mode())
 		if err != nil {
 			fmt.Println("Error saving config:", err)
 			return
 		}
 		fmt.Printf("Saved\n")
+	case checkCfgCmd:
+		checkConfig()
+
+	case confDiffCmd:
+		confDiff(data)
+
+	case restoreCfgCmd:
+		restoreConfig(data)
+
+	case configCmd:
+		configPath(data)
+
+	case peerCardCmd:
+		showPeerCard(data)
+
+	case importCmd:
+		if !importPeers(data) {
+			os.Exit(1)
+		}
+
 	case addPassCmd:
 		addPassword(data)
 
@@ -395,6 +554,10 @@ func main() {
 			fmt.Println("Invalid hostname or IPv6 address specified")
 			return
 		}
+		if isBatch(data) {
+			batchLookup(data, lookupHost)
+			return
+		}
 		input := data[0]
 		validIP, _ := regexp.MatchString(ipRegex, input)
 		validHost, _ := regexp.MatchString(hostRegex, input)
@@ -421,14 +584,47 @@ func main() {
 		}
 
 	case passGenCmd:
-		if len(data) > 0 && len(data[0]) > 0 {
-			fmt.Println(data[0] + "_" + randString(25, 50))
-		} else {
-			fmt.Println(randString(25, 50))
+		passGen(data)
+
+	case genKeyCmd:
+		genKey()
+
+	case whoamiCmd:
+		whoami()
+
+	case genConfCmd:
+		genConf()
+
+	case migrateCmd:
+		if !migrateConfigFile() {
+			os.Exit(1)
 		}
 
+	case whereisCmd:
+		whereis()
+
+	case logReplayCmd:
+		if !logReplay(data) {
+			os.Exit(1)
+		}
+
+	case dnsCacheCmd:
+		manageDNSCache(data)
+
+	case hostsCmd:
+		manageHosts(data)
 
 	case pubKeyToIPcmd:
+		if PrivateKey != "" {
+			if !privateToIP(PrivateKey) {
+				os.Exit(1)
+			}
+			return
+		}
+		if isBatch(data) {
+			batchLookup(data, lookupKey)
+			return
+		}
 		var ip []byte
 		if len(data) > 0 {
 			if len(data[0]) == 52 || len(data[0]) == 54 {
@@ -600,44 +796,7 @@ func main() {
 		outputPing(ping)
 
 	case logCmd:
-		user, err := adminConnect()
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		globalData.User = user
-		response := make(chan *admin.LogMessage)
-		globalData.LoggingStreamID, err =
-			globalData.User.AdminLog_subscribe(LogLevel, LogFile, LogFileLine, response)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
-		}
-		format := "%d %d %s %s:%d %s\n" // TODO: add user formatted output
-		counter := 1
-
-		// Spawn a routine to ping cjdns every 10 seconds to keep the connection alive
-		go func() {
-			for {
-				timeout := 10 * time.Second
-				time.Sleep(timeout)
-				err := globalData.User.Ping()
-
-				if err != nil {
-					fmt.Println("Error sending periodic ping to cjdns:", err)
-					return
-				}
-			}
-		}()
-		for {
-			input, ok := <-response
-			if !ok {
-				fmt.Println("Error reading log response from cjdns.")
-				return
-			}
-			fmt.Printf(format, counter, input.Time, input.Level, input.File, input.Line, input.Message)
-			counter++
-		}
+		streamLog(globalData, c)
 
 	case peerCmd:
 		user, err := adminConnect()
@@ -693,10 +852,17 @@ func main() {
 		}
 
 		table.SortByQuality()
+		var ips []string
+		for _, v := range table {
+			if v.Link >= 1 {
+				ips = append(ips, v.IP.String())
+			}
+		}
+		names := resolveIPs(ips)
 		k := 1
 		for _, v := range table {
 			if v.Link >= 1 {
-				fmt.Printf("%d IP: %v -- Version: %d -- Path: %s -- Link: %s\n", k, v.IP, v.Version, v.Path, v.Link)
+				fmt.Printf("%d IP: %v -- Version: %d -- Path: %s -- Link: %s\n", k, withHostname(v.IP.String(), names), v.Version, v.Path, v.Link)
 				k++
 			}
 		}
@@ -0,0 +1,60 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"sort"
+)
+
+// Returns the names of every interface type in the config, sorted
+func interfaceNames(conf map[string]interface{}) (names []string) {
+	is, _ := conf["interfaces"].(map[string]interface{})
+	for name := range is {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Returns every settings block for the named interface type. Older configs
+// store a single object per interface type while newer ones use an array.
+func interfaceBlocks(conf map[string]interface{}, name string) (blocks []map[string]interface{}) {
+	is, _ := conf["interfaces"].(map[string]interface{})
+	switch v := is[name].(type) {
+	case map[string]interface{}:
+		blocks = append(blocks, v)
+	case []interface{}:
+		for _, b := range v {
+			if block, ok := b.(map[string]interface{}); ok {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return
+}
+
+// Returns the connectTo section of an interface block, or nil if it has none
+func connectTo(block map[string]interface{}) map[string]interface{} {
+	peers, _ := block["connectTo"].(map[string]interface{})
+	return peers
+}
+
+// Returns the sorted keys of a map
+func sortedKeys(m map[string]interface{}) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
@@ -0,0 +1,251 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Prints the differences between two config files
+func confDiff(data []string) {
+	if len(data) != 2 {
+		fmt.Println("You must specify two configuration files to compare")
+		return
+	}
+	a, err := loadExtConfig(data[0])
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return
+	}
+	b, err := loadExtConfig(data[1])
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return
+	}
+
+	changes := diffConfigs(a, b)
+	if len(changes) == 0 {
+		fmt.Println("No differences found")
+		return
+	}
+	fmt.Printf("--- %v\n+++ %v\n", data[0], data[1])
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+}
+
+// Shows how conf differs from what is currently saved in OutFile and asks
+// the user whether to overwrite it. Returns true if it is OK to save.
+func confirmOverwrite(conf map[string]interface{}) bool {
+	if _, err := os.Stat(OutFile); err != nil {
+		return true
+	}
+
+	old, err := loadExtConfig(OutFile)
+	if err != nil {
+		fmt.Printf("Unable to compare with %v: %v\n", OutFile, err)
+	} else if changes := diffConfigs(old, conf); len(changes) == 0 {
+		fmt.Printf("No changes to %v\n", OutFile)
+	} else {
+		fmt.Printf("Changes to %v:\n", OutFile)
+		for _, c := range changes {
+			fmt.Println("\t" + c)
+		}
+	}
+
+	fmt.Printf("Overwrite %v? [y/N]: ", OutFile)
+	return gotYes(false)
+}
+
+// Returns a line for every semantic difference between configs a and b.
+// Lines start with '+' for additions, '-' for removals and '~' for changes.
+func diffConfigs(a, b map[string]interface{}) (changes []string) {
+	changes = append(changes, diffPeers(a, b)...)
+	changes = append(changes, diffPasswords(a, b)...)
+	changes = append(changes, diffSettings(a, b)...)
+	return
+}
+
+// Compares the connectTo sections of every interface
+func diffPeers(a, b map[string]interface{}) (changes []string) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(interfaceNames(a), interfaceNames(b)...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		aBlocks := interfaceBlocks(a, name)
+		bBlocks := interfaceBlocks(b, name)
+		for i := 0; i < len(aBlocks) || i < len(bBlocks); i++ {
+			var aPeers, bPeers map[string]interface{}
+			if i < len(aBlocks) {
+				aPeers = connectTo(aBlocks[i])
+			}
+			if i < len(bBlocks) {
+				bPeers = connectTo(bBlocks[i])
+			}
+			iface := fmt.Sprintf("%v[%d]", name, i)
+
+			for _, addr := range sortedKeys(aPeers) {
+				if _, ok := bPeers[addr]; !ok {
+					changes = append(changes, fmt.Sprintf("- %v peer %v", iface, addr))
+				}
+			}
+			for _, addr := range sortedKeys(bPeers) {
+				oldPeer, ok := aPeers[addr]
+				if !ok {
+					changes = append(changes, fmt.Sprintf("+ %v peer %v", iface, addr))
+					continue
+				}
+				prefix := fmt.Sprintf("%v peer %v ", iface, addr)
+				old, _ := oldPeer.(map[string]interface{})
+				peer, _ := bPeers[addr].(map[string]interface{})
+				changes = append(changes, diffFlat(prefix, flatten(old), flatten(peer))...)
+			}
+		}
+	}
+	return
+}
+
+// Compares authorizedPasswords, matching entries by password
+func diffPasswords(a, b map[string]interface{}) (changes []string) {
+	aPass := passwordEntries(a)
+	bPass := passwordEntries(b)
+	for _, p := range sortedKeys(aPass) {
+		if _, ok := bPass[p]; !ok {
+			changes = append(changes, "- password "+maskPassword(p))
+		}
+	}
+	for _, p := range sortedKeys(bPass) {
+		old, ok := aPass[p]
+		if !ok {
+			changes = append(changes, "+ password "+maskPassword(p))
+			continue
+		}
+		oldEntry, _ := old.(map[string]interface{})
+		entry, _ := bPass[p].(map[string]interface{})
+		changes = append(changes, diffFlat("password "+maskPassword(p)+" ", flatten(oldEntry), flatten(entry))...)
+	}
+	return
+}
+
+// Returns the authorizedPasswords entries keyed by their password
+func passwordEntries(conf map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	passwords, _ := conf["authorizedPasswords"].([]interface{})
+	for _, p := range passwords {
+		entry, _ := p.(map[string]interface{})
+		if pass, ok := entry["password"].(string); ok {
+			out[pass] = entry
+		}
+	}
+	return out
+}
+
+// Compares every other setting in the configs
+func diffSettings(a, b map[string]interface{}) []string {
+	return diffFlat("", flatten(a), flatten(b))
+}
+
+// Compares two flattened configs
+func diffFlat(prefix string, a, b map[string]string) (changes []string) {
+	var paths []string
+	for path := range a {
+		paths = append(paths, path)
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		oldVal, inA := a[path]
+		newVal, inB := b[path]
+		switch {
+		case !inA:
+			changes = append(changes, fmt.Sprintf("+ %v%v = %v", prefix, path, maskSetting(path, newVal)))
+		case !inB:
+			changes = append(changes, fmt.Sprintf("- %v%v = %v", prefix, path, maskSetting(path, oldVal)))
+		case oldVal != newVal:
+			changes = append(changes, fmt.Sprintf("~ %v%v = %v -> %v", prefix, path,
+				maskSetting(path, oldVal), maskSetting(path, newVal)))
+		}
+	}
+	return
+}
+
+// Flattens a config in to a map of paths, such as "router.interface.type",
+// to JSON encoded values. Peers and authorized passwords are left out as
+// they are compared separately.
+func flatten(v interface{}) map[string]string {
+	out := make(map[string]string)
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, child := range t {
+				if path == "" && k == "authorizedPasswords" {
+					continue
+				}
+				if k == "connectTo" && strings.HasPrefix(path, "interfaces.") {
+					continue
+				}
+				if path == "" {
+					walk(k, child)
+				} else {
+					walk(path+"."+k, child)
+				}
+			}
+		case []interface{}:
+			for i, child := range t {
+				walk(fmt.Sprintf("%v[%d]", path, i), child)
+			}
+		default:
+			raw, _ := json.Marshal(t)
+			out[path] = string(raw)
+		}
+	}
+	walk("", v)
+	return out
+}
+
+// Hides the value of any setting which holds a secret
+func maskSetting(path, value string) string {
+	if strings.HasSuffix(path, "password") || strings.HasSuffix(path, "privateKey") {
+		var s string
+		if json.Unmarshal([]byte(value), &s) == nil {
+			return `"` + maskPassword(s) + `"`
+		}
+	}
+	return value
+}
+
+// Hides all but the start of a password
+func maskPassword(p string) string {
+	if len(p) <= 4 {
+		return "****"
+	}
+	return p[:2] + "******"
+}
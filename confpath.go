@@ -0,0 +1,277 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// One step in a config path such as interfaces.UDPInterface[0].bind
+type pathElem struct {
+	Key     string
+	Index   int
+	IsIndex bool
+	Append  bool // [+] adds a new element to the end of an array
+}
+
+func (e pathElem) String() string {
+	switch {
+	case e.Append:
+		return "[+]"
+	case e.IsIndex:
+		return fmt.Sprintf("[%d]", e.Index)
+	}
+	return e.Key
+}
+
+// Splits a path in to its elements. Keys containing dots may be quoted
+// inside brackets, for example connectTo["1.2.3.4:5678"].
+func parsePath(path string) (elems []pathElem, err error) {
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			if i == 0 || i == len(path)-1 {
+				return nil, fmt.Errorf("Invalid path '%v'", path)
+			}
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if path[i+1:] != "" && path[i+1] == '"' {
+				end = strings.Index(path[i+2:], `"]`)
+				if end < 0 {
+					return nil, fmt.Errorf("Unterminated quote in path '%v'", path)
+				}
+				elems = append(elems, pathElem{Key: path[i+2 : i+2+end]})
+				i += end + 4
+				continue
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated '[' in path '%v'", path)
+			}
+			inside := path[i+1 : i+end]
+			if inside == "+" {
+				elems = append(elems, pathElem{Append: true})
+			} else if n, err := strconv.Atoi(inside); err == nil && n >= 0 {
+				elems = append(elems, pathElem{Index: n, IsIndex: true})
+			} else {
+				return nil, fmt.Errorf("Invalid index '%v' in path '%v'", inside, path)
+			}
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			elems = append(elems, pathElem{Key: path[i : i+end]})
+			i += end
+		}
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("Empty path")
+	}
+	return
+}
+
+// Returns the value found at path
+func getPath(node interface{}, path []pathElem) (interface{}, error) {
+	for n, e := range path {
+		where := pathString(path[:n+1])
+		switch {
+		case e.Append:
+			return nil, fmt.Errorf("[+] can only be used when setting a value")
+		case e.IsIndex:
+			list, ok := node.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%v is not an array", pathString(path[:n]))
+			}
+			if e.Index >= len(list) {
+				return nil, fmt.Errorf("%v does not exist", where)
+			}
+			node = list[e.Index]
+		default:
+			m, ok := node.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%v is not an object", pathString(path[:n]))
+			}
+			if node, ok = m[e.Key]; !ok {
+				return nil, fmt.Errorf("%v does not exist", where)
+			}
+		}
+	}
+	return node, nil
+}
+
+// Sets the value at path and returns the updated node. Missing objects along
+// the way are created.
+func setPath(node interface{}, path []pathElem, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	e := path[0]
+
+	if e.IsIndex || e.Append {
+		list, ok := node.([]interface{})
+		if node == nil && e.Append {
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("expected an array but found %T", node)
+		}
+		if e.Append {
+			child, err := setPath(nil, path[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			return append(list, child), nil
+		}
+		if e.Index >= len(list) {
+			return nil, fmt.Errorf("index %d is out of range, use [+] to append", e.Index)
+		}
+		child, err := setPath(list[e.Index], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		list[e.Index] = child
+		return list, nil
+	}
+
+	m, ok := node.(map[string]interface{})
+	if node == nil {
+		m, ok = make(map[string]interface{}), true
+	}
+	if !ok {
+		return nil, fmt.Errorf("expected an object but found %T", node)
+	}
+	child, err := setPath(m[e.Key], path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	m[e.Key] = child
+	return m, nil
+}
+
+// Joins path elements back in to a path string
+func pathString(path []pathElem) string {
+	var out string
+	for _, e := range path {
+		switch {
+		case e.IsIndex || e.Append:
+			out += e.String()
+		case strings.ContainsAny(e.Key, ".[]"):
+			out += `["` + e.Key + `"]`
+		case out == "":
+			out = e.Key
+		default:
+			out += "." + e.Key
+		}
+	}
+	return out
+}
+
+// Handles "config get <path>" and "config set <path> <value>", exiting with
+// an error status if either fails
+func configPath(data []string) {
+	fail := func(a ...interface{}) {
+		fmt.Println(a...)
+		os.Exit(1)
+	}
+	if len(data) < 2 {
+		fail("Usage: config get <path> or config set <path> <value>")
+	}
+	path, err := parsePath(data[1])
+	if err != nil {
+		fail(err)
+	}
+
+	switch data[0] {
+	case "get":
+		if err := setConfigFile(); err != nil {
+			fail(err)
+		}
+		conf, err := loadExtConfig(File)
+		if err != nil {
+			fail("Error loading config:", err)
+		}
+		value, err := getPath(conf, path)
+		if err != nil {
+			fail(err)
+		}
+		if s, ok := value.(string); ok {
+			fmt.Println(s)
+			return
+		}
+		out, _ := json.MarshalIndent(value, "", "\t")
+		fmt.Println(string(out))
+
+	case "set":
+		if len(data) != 3 {
+			fail("You must specify the value to set")
+		}
+		if err := setConfigPath(path, data[2]); err != nil {
+			fail(err)
+		}
+
+	default:
+		fail("Unknown config action", data[0])
+	}
+}
+
+// Parses a value given to config set. It must be JSON, so strings have to be
+// quoted, unless --string was given to use it exactly as it is.
+func parseConfigValue(input string) (value interface{}, err error) {
+	if ConfigString {
+		return input, nil
+	}
+	if err = parseJSON("", []byte(input), &value); err != nil {
+		return nil, fmt.Errorf("Invalid JSON value, quote strings like '\"text\"' or use --string: %v", err)
+	}
+	return
+}
+
+// Sets a value in the config file and saves it
+func setConfigPath(path []pathElem, input string) error {
+	value, err := parseConfigValue(input)
+	if err != nil {
+		return err
+	}
+
+	if err := setConfigFile(); err != nil {
+		return err
+	}
+	unlock, err := lockConfigEdit()
+	if err != nil {
+		return fmt.Errorf("Unable to lock config: %v", err)
+	}
+	defer unlock()
+
+	fmt.Printf("Loading configuration from: %v... ", File)
+	conf, err := loadExtConfig(File)
+	if err != nil {
+		return fmt.Errorf("Error loading config: %v", err)
+	}
+	fmt.Printf("Loaded\n")
+
+	if _, err = setPath(conf, path, value); err != nil {
+		return fmt.Errorf("Unable to set %v: %v", pathString(path), err)
+	}
+
+	if !writeConfig(conf) {
+		return fmt.Errorf("%v was not changed", pathString(path))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []pathElem
+		err  bool
+	}{
+		{"admin.bind", []pathElem{{Key: "admin"}, {Key: "bind"}}, false},
+		{"interfaces.UDPInterface[0].bind", []pathElem{{Key: "interfaces"}, {Key: "UDPInterface"}, {Index: 0, IsIndex: true}, {Key: "bind"}}, false},
+		{`connectTo["1.2.3.4:5"]`, []pathElem{{Key: "connectTo"}, {Key: "1.2.3.4:5"}}, false},
+		{"list[+]", []pathElem{{Key: "list"}, {Append: true}}, false},
+		{"", nil, true},
+		{".admin", nil, true},
+		{"a[", nil, true},
+		{"a[-1]", nil, true},
+		{`a["b`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.in, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+		if !tt.err && pathString(got) != tt.in {
+			t.Errorf("%q: joined back as %q", tt.in, pathString(got))
+		}
+	}
+}
+
+func testConf() map[string]interface{} {
+	return map[string]interface{}{
+		"admin": map[string]interface{}{"bind": "127.0.0.1:11234"},
+		"list":  []interface{}{"a"},
+	}
+}
+
+func TestGetPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want interface{}
+		err  bool
+	}{
+		{"admin.bind", "127.0.0.1:11234", false},
+		{"list[0]", "a", false},
+		{"list[1]", nil, true},
+		{"admin.missing", nil, true},
+		{"admin[0]", nil, true},
+		{"list.x", nil, true},
+		{"list[+]", nil, true},
+	}
+	for _, tt := range tests {
+		path, _ := parsePath(tt.path)
+		got, err := getPath(testConf(), path)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("%v: got %v, %v", tt.path, got, err)
+		}
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		value interface{}
+		err   bool
+	}{
+		{"admin.bind", "0.0.0.0:1", false},
+		{"router.interface.type", "TUNInterface", false},
+		{"list[0]", "b", false},
+		{"list[+]", "c", false},
+		{"new[+].x", 1.0, false},
+		{"list[5]", "x", true},
+		{"admin[0]", "x", true},
+		{"list.x", "x", true},
+	}
+	for _, tt := range tests {
+		path, _ := parsePath(tt.path)
+		conf := testConf()
+		_, err := setPath(conf, path, tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: got error %v", tt.path, err)
+			continue
+		}
+		if tt.err || strings.Contains(tt.path, "[+]") {
+			continue
+		}
+		if got, err := getPath(conf, path[:len(path)-1]); err != nil || got == nil {
+			t.Errorf("%v: parent missing after set: %v", tt.path, err)
+		}
+	}
+
+	// Appending adds to the end of the existing array
+	conf := testConf()
+	path, _ := parsePath("list[+]")
+	setPath(conf, path, "b")
+	if got := conf["list"]; !reflect.DeepEqual(got, []interface{}{"a", "b"}) {
+		t.Errorf("append gave %v", got)
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	defer func() { ConfigString = false }()
+	tests := []struct {
+		in     string
+		string bool
+		want   interface{}
+		err    bool
+	}{
+		{`"text"`, false, "text", false},
+		{`12`, false, 12.0, false},
+		{`true`, false, true, false},
+		{`{"bind": "0.0.0.0:1"}`, false, map[string]interface{}{"bind": "0.0.0.0:1"}, false},
+		{`text`, false, nil, true},
+		{`{"bind": `, false, nil, true},
+		{`{"bind": `, true, `{"bind": `, false},
+		{`text`, true, "text", false},
+	}
+	for _, tt := range tests {
+		ConfigString = tt.string
+		got, err := parseConfigValue(tt.in)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q (string %v): got %v, %v", tt.in, tt.string, got, err)
+		}
+	}
+}
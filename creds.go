@@ -0,0 +1,254 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	envAdminAddr = "CJDNS_ADMIN_ADDR"
+	envAdminPass = "CJDNS_ADMIN_PASS"
+
+	defaultAdminAddr = "127.0.0.1"
+	defaultAdminPort = 11234
+)
+
+// A place the admin details can come from
+type credSource struct {
+	Name   string
+	Path   string
+	Status string
+}
+
+// The details needed to connect to cjdns, where each of them came from and
+// every source that was looked at
+type adminCreds struct {
+	admin.CjdnsAdminConfig
+	From    map[string]string
+	Checked []*credSource
+}
+
+// Records where a value came from if it was set
+func (c *adminCreds) set(field, value string, source *credSource) {
+	if value == "" {
+		return
+	}
+	switch field {
+	case "addr":
+		c.Addr = value
+	case "password":
+		c.Password = value
+	case "config":
+		c.Config = value
+	}
+	c.From[field] = source.Name
+	source.Status = "used"
+}
+
+// Sets the address and port from an address that may not include a port
+func (c *adminCreds) setBind(bind string, source *credSource) error {
+	if bind == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		c.set("addr", bind, source)
+		return nil
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("Invalid admin port '%v' from %v", port, source.Name)
+	}
+	c.set("addr", host, source)
+	c.Port = p
+	c.From["port"] = source.Name
+	return nil
+}
+
+// Copies everything a .cjdnsadmin file or profile holds
+func (c *adminCreds) setAll(node *admin.CjdnsAdminConfig, source *credSource) {
+	c.set("addr", node.Addr, source)
+	c.set("password", node.Password, source)
+	c.set("config", node.Config, source)
+	if node.Port != 0 {
+		c.Port = node.Port
+		c.From["port"] = source.Name
+	}
+}
+
+// Returns the home directory of the user who ran sudo, or of the current user
+// when not running under sudo. Every file cjdcmd keeps in a home directory is
+// found through this.
+func userHome() (string, error) {
+	if _, _, home, ok := sudoUser(); ok {
+		return home, nil
+	}
+	tUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return tUser.HomeDir, nil
+}
+
+// Returns the path of one of cjdcmd's files in the XDG config directory
+func xdgConfigFile(home, name string) string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "cjdcmd", name)
+}
+
+// Returns the .cjdnsadmin file in the XDG config directory
+func xdgCjdnsadmin(home string) string {
+	return xdgConfigFile(home, "cjdnsadmin")
+}
+
+// Works out how to connect to cjdns. Highest precedence first:
+//
+//	--admin-addr and --admin-pass
+//	CJDNS_ADMIN_ADDR and CJDNS_ADMIN_PASS
+//	the file given with --cjdnsadmin
+//	the admin section of the config given with --file
+//	~/.cjdnsadmin
+//	$XDG_CONFIG_HOME/cjdcmd/cjdnsadmin
+//
+// Flags and environment variables override single settings, while only the
+// first of the files found is used. The config file given with --file is
+// always the one edited.
+func resolveAdmin() (c *adminCreds, err error) {
+	c = &adminCreds{From: make(map[string]string)}
+	flags := &credSource{Name: "--admin-addr/--admin-pass", Status: "not given"}
+	env := &credSource{Name: envAdminAddr + "/" + envAdminPass, Status: "not set"}
+	cjdnsadmin := &credSource{Name: "--cjdnsadmin", Path: userCjdnsadmin, Status: "not given"}
+	conf := &credSource{Name: "--file", Status: "not given"}
+	if userSpecifiedFile {
+		conf.Path = File
+	}
+	home := &credSource{Name: "~/.cjdnsadmin", Status: "not found"}
+	xdg := &credSource{Name: "XDG config", Status: "not found"}
+	c.Checked = []*credSource{flags, env, cjdnsadmin, conf, home, xdg}
+
+	if dir, err := userHome(); err == nil {
+		home.Path = filepath.Join(dir, ".cjdnsadmin")
+		xdg.Path = xdgCjdnsadmin(dir)
+	}
+
+	// Only the first file found is used
+	switch {
+	case userSpecifiedCjdnsadmin:
+		node, err := readCjdnsadmin(userCjdnsadmin)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading cjdnsadmin file: %v", err)
+		}
+		c.setAll(node, cjdnsadmin)
+
+	case userSpecifiedFile:
+		cjdroute, err := readConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load configuration file: %v", err)
+		}
+		c.set("password", cjdroute.Admin.Password, conf)
+		if err = c.setBind(cjdroute.Admin.Bind, conf); err != nil {
+			return nil, err
+		}
+
+	default:
+		for _, s := range []*credSource{home, xdg} {
+			if s.Path == "" || !fileExists(s.Path) {
+				continue
+			}
+			node, err := readCjdnsadmin(s.Path)
+			if err != nil {
+				return nil, fmt.Errorf("Error loading %v: %v", s.Path, err)
+			}
+			c.setAll(node, s)
+			break
+		}
+	}
+	for _, s := range []*credSource{home, xdg} {
+		if s.Status == "not found" && s.Path != "" && fileExists(s.Path) {
+			s.Status = "skipped"
+		}
+	}
+
+	if err = c.setBind(os.Getenv(envAdminAddr), env); err != nil {
+		return nil, err
+	}
+	c.set("password", os.Getenv(envAdminPass), env)
+
+	if err = c.setBind(AdminBind, flags); err != nil {
+		return nil, err
+	}
+	c.set("password", AdminPassword, flags)
+
+	// The config file being worked on always comes from --file
+	if userSpecifiedFile {
+		c.set("config", File, conf)
+	}
+
+	if c.Addr == "" {
+		c.Addr = defaultAdminAddr
+		c.From["addr"] = "default"
+	}
+	if c.Port == 0 {
+		c.Port = defaultAdminPort
+		c.From["port"] = "default"
+	}
+	return
+}
+
+// Shows where the admin details and config file would be taken from
+func whereis() {
+	c, err := resolveAdmin()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	password := "(none)"
+	if c.Password != "" {
+		password = maskPassword(c.Password)
+	}
+	config := c.Config
+	if config == "" {
+		config = "(none)"
+	}
+
+	fmt.Println("Using:")
+	for _, f := range [][2]interface{}{
+		{"addr", c.Addr}, {"port", c.Port}, {"password", password}, {"config", config},
+	} {
+		from, ok := c.From[f[0].(string)]
+		if !ok {
+			from = "nowhere"
+		}
+		fmt.Printf("\t%-10v%-40v from %v\n", f[0], f[1], from)
+	}
+	if Node != "" {
+		fmt.Printf("\t%-10v%v\n", "profile", Node)
+	}
+
+	fmt.Println("Checked, highest precedence first:")
+	for _, s := range c.Checked {
+		fmt.Printf("\t%-36v%-10v %v\n", s.Name, s.Status, s.Path)
+	}
+}
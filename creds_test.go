@@ -0,0 +1,70 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Sets the globals that choose where the admin details come from for the rest
+// of a test
+func setAdminFiles(t *testing.T, cjdnsadmin, file string, specified bool) {
+	oldCjdnsadmin, oldSpecified := userCjdnsadmin, userSpecifiedCjdnsadmin
+	oldFile, oldFileSpecified := File, userSpecifiedFile
+	userCjdnsadmin, userSpecifiedCjdnsadmin = cjdnsadmin, cjdnsadmin != ""
+	File, userSpecifiedFile = file, specified
+	t.Cleanup(func() {
+		userCjdnsadmin, userSpecifiedCjdnsadmin = oldCjdnsadmin, oldSpecified
+		File, userSpecifiedFile = oldFile, oldFileSpecified
+	})
+}
+
+func TestResolveAdminDerivedFile(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "cjdroute.conf")
+	cjdnsadmin := filepath.Join(dir, "cjdnsadmin")
+	if err := ioutil.WriteFile(conf, []byte(`{"admin": {"bind": "127.0.0.1:11235", "password": "fromconf"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cjdnsadmin, []byte(`{"addr": "127.0.0.1", "port": 11234, "password": "fromadmin", "config": "`+conf+`"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		cjdnsadmin string
+		specified  bool
+		password   string
+		from       string
+	}{
+		// File filled in from the cjdnsadmin file must not take it over
+		{cjdnsadmin, false, "fromadmin", "--cjdnsadmin"},
+		{"", true, "fromconf", "--file"},
+	} {
+		setAdminFiles(t, test.cjdnsadmin, conf, test.specified)
+		c, err := resolveAdmin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Password != test.password {
+			t.Errorf("specified %v: password %q, want %q", test.specified, c.Password, test.password)
+		}
+		for _, field := range []string{"password", "config"} {
+			if c.From[field] != test.from {
+				t.Errorf("specified %v: %v from %q, want %q", test.specified, field, c.From[field], test.from)
+			}
+		}
+	}
+}
@@ -14,154 +14,383 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/miekg/dns"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Lookup the IP address using HypeDNS
-func lookupHypeDNS(hostname string) (response string, err error) {
-	c := new(dns.Client)
+const (
+	defaultResolverMode    = "both"
+	defaultResolverTimeout = 2 * time.Second
+	defaultDNSWorkers      = 16
+	defaultDNSDeadline     = 10 * time.Second
 
+	dnsPort      = "53"
+	hypeHostPort = "8000"
+	hypeHostSet  = "/_hypehost/set?hostname="
+	hypeHostGet  = "/_hypehost/get"
+)
+
+// HypeDNS, used when no other resolvers are configured
+var defaultResolvers = []string{"fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535"}
+
+// The ways names can be resolved with --resolver-mode
+var resolverModes = map[string]bool{"system": true, "mesh": true, "both": true}
+
+var (
+	meshResolverList []string
+	meshResolverOnce sync.Once
+)
+
+// Returns the resolvers to send mesh DNS queries to, in the order they should
+// be tried. They come from --resolver, then the resolvers list in the
+// .cjdnsadmin file, and finally HypeDNS.
+func meshResolvers() []string {
+	meshResolverOnce.Do(func() {
+		list := []string(Resolvers)
+		if len(list) == 0 {
+			list = cjdnsadminResolvers()
+		}
+		if len(list) == 0 {
+			list = defaultResolvers
+		}
+		for _, r := range list {
+			meshResolverList = append(meshResolverList, withPort(r, dnsPort))
+		}
+	})
+	return meshResolverList
+}
+
+// Adds a port to an address that doesn't have one
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// Returns the resolvers listed in the .cjdnsadmin file that would be used to
+// connect to cjdns, if there are any
+func cjdnsadminResolvers() []string {
+	var files []string
+	if userSpecifiedCjdnsadmin {
+		files = []string{userCjdnsadmin}
+	} else if home, err := userHome(); err == nil {
+		files = []string{filepath.Join(home, ".cjdnsadmin"), xdgCjdnsadmin(home)}
+	}
+	for _, file := range files {
+		if !fileExists(file) {
+			continue
+		}
+		f := new(cjdnsadminFile)
+		if err := readJSONFile(file, f); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading resolvers from %v: %v\n", file, err)
+			return nil
+		}
+		return f.Resolvers
+	}
+	return nil
+}
+
+// Asks each mesh resolver in turn, giving each --resolver-timeout to answer.
+// The first answer with records in it is returned, or the last empty one if
+// none of them had any.
+func queryMeshDNS(name string, qtype uint16) (r *dns.Msg, err error) {
+	c := &dns.Client{
+		DialTimeout:  ResolverTimeout,
+		ReadTimeout:  ResolverTimeout,
+		WriteTimeout: ResolverTimeout,
+	}
 	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(hostname), dns.TypeAAAA)
+	m.SetQuestion(name, qtype)
 	m.RecursionDesired = true
 
-	r, _, err := c.Exchange(m, "[fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535]:53")
-	if r == nil || err != nil {
-		return
+	for _, server := range meshResolvers() {
+		resp, _, qerr := c.Exchange(m, server)
+		if resp == nil || qerr != nil {
+			err = qerr
+			continue
+		}
+		r = resp
+		if len(resp.Answer) > 0 {
+			return r, nil
+		}
+	}
+	if r != nil {
+		return r, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no resolvers answered")
 	}
+	return nil, err
+}
 
-	// Stuff must be in the answer section
+// Returns the values of the answers of the given type, such as "AAAA", and
+// how long the shortest lived of them may be cached for
+func answerValues(r *dns.Msg, rrtype string) (values []string, ttl time.Duration) {
+	ttl = dnsCacheTTL
 	for _, a := range r.Answer {
-		columns := strings.Fields(a.String()) //column 4 holds the ip address
-		return padIPv6(net.ParseIP(columns[4])), nil
+		// Columns are name, TTL, class, type and value
+		columns := strings.Fields(a.String())
+		if len(columns) > 4 && columns[3] == rrtype {
+			values = append(values, columns[4])
+			if t := time.Duration(a.Header().Ttl) * time.Second; t < ttl {
+				ttl = t
+			}
+		}
 	}
 	return
 }
 
-// Lookup the hostname for an IP address using HypeDNS
-// This probably needs work but I don't really know what I'm doing :)
-func reverseHypeDNSLookup(ip string) (response string, err error) {
-	c := new(dns.Client)
-
-	m := new(dns.Msg)
-	thing, err := dns.ReverseAddr(ip)
+// Looks up the IP addresses of a hostname using the mesh resolvers
+func lookupMeshDNS(hostname string) (ips []string, ttl time.Duration, err error) {
+	r, err := queryMeshDNS(dns.Fqdn(hostname), dns.TypeAAAA)
 	if err != nil {
 		return
 	}
-	m.SetQuestion(thing, dns.TypePTR)
-	m.RecursionDesired = true
+	ips, ttl = answerValues(r, "AAAA")
+	return
+}
 
-	r, _, err := c.Exchange(m, "[fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535]:53")
-	if r == nil || err != nil {
+// Looks up the hostnames of an IP address using the mesh resolvers
+func reverseMeshDNSLookup(ip string) (names []string, ttl time.Duration, err error) {
+	arpa, err := dns.ReverseAddr(ip)
+	if err != nil {
 		return
 	}
-
-	// Stuff must be in the answer section
-	for _, a := range r.Answer {
-		columns := strings.Fields(a.String()) //column 4 holds the ip address
-		return columns[4], nil
+	r, err := queryMeshDNS(arpa, dns.TypePTR)
+	if err != nil {
+		return
 	}
+	names, ttl = answerValues(r, "PTR")
 	return
 }
 
-// Create a HypeDNS name for this device
+// Gets or sets this device's HypeDNS name, using the hypehost service on each
+// mesh resolver in turn
 func setHypeDNS(hostname string) (response string, err error) {
-	setLoc := "/_hypehost/set?hostname="
-	getLoc := "/_hypehost/get"
-	nodeInfoHost :=  "http://[fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535]:8000"
+	loc := hypeHostGet
+	if len(hostname) > 0 {
+		loc = hypeHostSet + hostname
+	}
+	client := &http.Client{Timeout: ResolverTimeout}
 
-	if len(hostname) == 0 {
-		// The request is a "get"
-		resp, err := http.Get(nodeInfoHost + getLoc)
+	var body []byte
+	for _, server := range meshResolvers() {
+		host, _, _ := net.SplitHostPort(server)
+		var resp *http.Response
+		resp, err = client.Get("http://" + net.JoinHostPort(host, hypeHostPort) + loc)
 		if err != nil {
-			fmt.Println("Got an error, %s", err)
-			err = fmt.Errorf("Got an error when attempting to retrieve " +
-			"hostname. This is usually because you can't connect to HypeDNS. " +
-			"Try again later")
-			return "", err
+			continue
+		}
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			break
 		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		fmt.Println("You are: " + string(body))
-		return "", nil
 	}
-	// The request is a "set"
-	resp, err := http.Get(nodeInfoHost + setLoc + hostname)
 	if err != nil {
-		fmt.Println("Got an error, %s", err)
-		err = fmt.Errorf("Got an error when attempting to change hostname. " +
-		"Try again later")
+		fmt.Println("Got an error:", err)
+		if len(hostname) == 0 {
+			err = fmt.Errorf("Got an error when attempting to retrieve " +
+				"hostname. This is usually because you can't connect to HypeDNS. " +
+				"Try again later")
+		} else {
+			err = fmt.Errorf("Got an error when attempting to change hostname. " +
+				"Try again later")
+		}
 		return "", err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	fmt.Println("Hostname " + string(body) + " created.")
+
+	if len(hostname) == 0 {
+		fmt.Println("You are: " + string(body))
+	} else {
+		fmt.Println("Hostname " + string(body) + " created.")
+	}
 	return "", nil
 }
 
-// Resolve an IP to a domain name using the system DNS settings first, then HypeDNS
-func resolveIP(ip string) (hostname string, err error) {
-	var try2 string
-    if NoDNS {
-        hostname = ip
-    } else {
-        // try the system DNS setup
-        result, _ := net.LookupAddr(ip)
-        if len(result) > 0 {
-            goto end
-        }
-
-        // Try HypeDNS
-        try2, err = reverseHypeDNSLookup(ip)
-        if try2 == "" || err != nil {
-            err = fmt.Errorf("Unable to resolve IP address. This is usually caused by not having a route to hypedns. Please try again in a few seconds.")
-            return
-        }
-        result = append(result, try2)
-    end:
-        for _, addr := range result {
-            hostname = addr
-        }
-    }
-	// Trim the trailing period becuase it annoys me
-	if hostname[len(hostname)-1] == '.' {
-		hostname = hostname[:len(hostname)-1]
+// Returns an error from the system resolver unless it means the name
+// doesn't exist
+func systemLookupErr(err error) error {
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil
+	}
+	return err
+}
+
+// Looks up the hostnames of an address with the system resolver, the mesh
+// resolvers, or both, as chosen by --resolver-mode. No names and no error
+// means the address has no name.
+func lookupNames(ip string) (names []string, ttl time.Duration, err error) {
+	ttl = dnsCacheTTL
+	if ResolverMode != "mesh" {
+		ctx, cancel := context.WithTimeout(context.Background(), ResolverTimeout)
+		names, err = net.DefaultResolver.LookupAddr(ctx, ip)
+		cancel()
+		if len(names) > 0 {
+			return names, ttl, nil
+		}
+		err = systemLookupErr(err)
+	}
+	if ResolverMode != "system" {
+		names, ttl, err = reverseMeshDNSLookup(ip)
 	}
 	return
 }
 
-// Resolve a hostname to an IP address using the system DNS settings first, then HypeDNS
-func resolveHost(hostname string) (ips []string, err error) {
-	var ip string
-	// Try the system DNS setup
-	result, _ := net.LookupHost(hostname)
-	if len(result) > 0 {
-		goto end
+// Looks up the addresses of a hostname in the same way as lookupNames
+func lookupAddrs(hostname string) (addrs []string, ttl time.Duration, err error) {
+	ttl = dnsCacheTTL
+	if ResolverMode != "mesh" {
+		ctx, cancel := context.WithTimeout(context.Background(), ResolverTimeout)
+		addrs, err = net.DefaultResolver.LookupHost(ctx, hostname)
+		cancel()
+		if len(addrs) > 0 {
+			return addrs, ttl, nil
+		}
+		err = systemLookupErr(err)
+	}
+	if ResolverMode != "system" {
+		addrs, ttl, err = lookupMeshDNS(hostname)
 	}
+	return
+}
 
-	// Try with hypedns
-	ip, err = lookupHypeDNS(hostname)
+// Resolve an IP to a domain name using cjdcmd's hosts file, then the DNS
+// cache, then the system DNS settings, then the mesh resolvers, as allowed by
+// --resolver-mode
+func resolveIP(ip string) (hostname string, err error) {
+	if NoDNS {
+		return ip, nil
+	}
 
-	if ip == "" || err != nil {
-		err = fmt.Errorf("Unable to resolve hostname. This is usually caused by not having a route to hypedns. Please try again in a few seconds.")
+	key := addrKey(ip)
+	if name := localHosts().name(key); name != "" {
+		return name, nil
+	}
+	result, ok := cachedAnswer(addrsTable, dnsCacheKey(key))
+	if !ok {
+		var ttl time.Duration
+		if result, ttl, err = lookupNames(ip); err == nil {
+			cacheAnswer(addrsTable, dnsCacheKey(key), result, ttl)
+		}
+	}
+	if len(result) == 0 {
+		err = fmt.Errorf("Unable to resolve IP address. This is usually caused by not having a route to your resolvers. Please try again in a few seconds.")
 		return
 	}
 
-	result = append(result, ip)
+	// Trim the trailing period becuase it annoys me
+	hostname = strings.TrimSuffix(result[len(result)-1], ".")
+	return
+}
+
+// Resolve a hostname to an IP address using cjdcmd's hosts file, then the
+// DNS cache, then the system DNS settings, then the mesh resolvers, as allowed
+// by --resolver-mode
+func resolveHost(hostname string) (ips []string, err error) {
+	key := strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if ips = localHosts().addrs(key); len(ips) > 0 {
+		return ips, nil
+	}
+	ips, ok := cachedAnswer(hostsTable, dnsCacheKey(key))
+	if !ok {
+		var result []string
+		var ttl time.Duration
+		result, ttl, err = lookupAddrs(hostname)
+		for _, addr := range result {
+			tIP := net.ParseIP(addr)
+			// Only grab the cjdns IP's
+			if tIP != nil && tIP[0] == 0xfc {
+				ips = append(ips, padIPv6(tIP))
+			}
+		}
+		if err == nil {
+			cacheAnswer(hostsTable, dnsCacheKey(key), ips, ttl)
+		}
+	}
+	if len(ips) == 0 {
+		err = fmt.Errorf("Unable to resolve hostname. This is usually caused by not having a route to your resolvers. Please try again in a few seconds.")
+	}
+	return
+}
+
+// Resolves the hostnames of many addresses at once, running at most
+// --dns-workers lookups at a time. Addresses that haven't been resolved by
+// the time --dns-deadline has passed are left out of the result.
+func resolveIPs(ips []string) map[string]string {
+	names := make(map[string]string)
+	if NoDNS || len(ips) == 0 {
+		return names
+	}
 
-end:
-	for _, addr := range result {
-		tIP := net.ParseIP(addr)
-		// Only grab the cjdns IP's
-		if tIP[0] == 0xfc {
-			ips = append(ips, padIPv6(net.ParseIP(addr)))
+	jobs := make(chan string, len(ips))
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		if !seen[ip] {
+			seen[ip] = true
+			jobs <- ip
 		}
 	}
+	close(jobs)
 
-	return
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+	for i := 0; i < DNSWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range jobs {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if name, err := resolveIP(ip); err == nil {
+					lock.Lock()
+					names[ip] = name
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	finished := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(DNSDeadline):
+		// Lookups still running are left to finish in the background, so
+		// their answers are still cached
+		close(stop)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	out := make(map[string]string, len(names))
+	for ip, name := range names {
+		out[ip] = name
+	}
+	return out
+}
+
+// Adds the hostname to an address if it has one
+func withHostname(ip string, names map[string]string) string {
+	if name := names[ip]; name != "" {
+		return ip + " (" + name + ")"
+	}
+	return ip
 }
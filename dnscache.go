@@ -0,0 +1,270 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long to keep answers from the system resolver, which doesn't say
+	dnsCacheTTL = time.Hour
+	// How long to remember that a name or address doesn't resolve
+	dnsNegativeTTL = 5 * time.Minute
+)
+
+// A cached answer. An empty list of values means there was no answer.
+type dnsCacheEntry struct {
+	Values  []string  `json:"values"`
+	Expires time.Time `json:"expires"`
+}
+
+// Hostnames and addresses that have been resolved before, shared by every
+// command and kept in between runs
+type dnsCache struct {
+	Hosts map[string]*dnsCacheEntry `json:"hosts"` // hostname to addresses
+	Addrs map[string]*dnsCacheEntry `json:"addrs"` // address to hostnames
+}
+
+var (
+	cache     *dnsCache
+	cacheNew  *dnsCache // only the entries added by this run
+	cacheLock sync.Mutex
+)
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{
+		Hosts: make(map[string]*dnsCacheEntry),
+		Addrs: make(map[string]*dnsCacheEntry),
+	}
+}
+
+// Returns the cache file, in $XDG_CACHE_HOME/cjdcmd or ~/.cache/cjdcmd
+func dnsCacheFile() (string, error) {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, err := userHome()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(dir, "cjdcmd", "dns.json"), nil
+}
+
+// Reads the cache file, returning an empty cache if there isn't one
+func readDNSCache() (*dnsCache, error) {
+	c := newDNSCache()
+	file, err := dnsCacheFile()
+	if err != nil {
+		return c, err
+	}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal(b, c); err != nil {
+		return newDNSCache(), fmt.Errorf("%v is damaged: %v", file, err)
+	}
+	if c.Hosts == nil {
+		c.Hosts = make(map[string]*dnsCacheEntry)
+	}
+	if c.Addrs == nil {
+		c.Addrs = make(map[string]*dnsCacheEntry)
+	}
+	return c, nil
+}
+
+// Loads the cache the first time it is needed. Must be called with cacheLock
+// held.
+func loadDNSCache() {
+	if cache != nil {
+		return
+	}
+	var err error
+	if cache, err = readDNSCache(); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read the DNS cache:", err)
+	}
+	cacheNew = newDNSCache()
+}
+
+// Returns the key an address is cached under, so that it is found however it
+// was written
+func addrKey(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return padIPv6(parsed)
+	}
+	return ip
+}
+
+// Returns the key an answer for name is cached under. Answers depend on
+// --resolver-mode and on which mesh resolvers were asked, so each combination
+// keeps its own entries.
+func dnsCacheKey(name string) string {
+	scope := ResolverMode
+	if ResolverMode != "system" {
+		scope += "@" + strings.Join(meshResolvers(), ",")
+	}
+	return scope + " " + name
+}
+
+// Returns the cached answer for a hostname or address if it hasn't expired
+func cachedAnswer(table func(*dnsCache) map[string]*dnsCacheEntry, key string) (values []string, ok bool) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	loadDNSCache()
+	e := table(cache)[key]
+	if e == nil || time.Now().After(e.Expires) {
+		return nil, false
+	}
+	return e.Values, true
+}
+
+// Caches an answer for ttl, or for dnsNegativeTTL if there was no answer
+func cacheAnswer(table func(*dnsCache) map[string]*dnsCacheEntry, key string, values []string, ttl time.Duration) {
+	if len(values) == 0 {
+		ttl = dnsNegativeTTL
+	}
+	e := &dnsCacheEntry{Values: values, Expires: time.Now().Add(ttl)}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	loadDNSCache()
+	table(cache)[key] = e
+	table(cacheNew)[key] = e
+}
+
+func hostsTable(c *dnsCache) map[string]*dnsCacheEntry { return c.Hosts }
+func addrsTable(c *dnsCache) map[string]*dnsCacheEntry { return c.Addrs }
+
+// Writes anything resolved during this run to the cache file. The file is
+// read again first so that other runs' answers are kept, and expired entries
+// are dropped.
+func saveDNSCache() {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if cacheNew == nil || len(cacheNew.Hosts)+len(cacheNew.Addrs) == 0 {
+		return
+	}
+	if err := writeDNSCache(cacheNew); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to save the DNS cache:", err)
+		return
+	}
+	cacheNew = newDNSCache()
+}
+
+// Merges the entries in to the cache file
+func writeDNSCache(entries *dnsCache) error {
+	file, err := dnsCacheFile()
+	if err != nil {
+		return err
+	}
+	if err = mkdirAllUser(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	// A damaged cache is simply started again
+	c, _ := readDNSCache()
+	now := time.Now()
+	for _, table := range []map[string]*dnsCacheEntry{c.Hosts, c.Addrs} {
+		for key, e := range table {
+			if now.After(e.Expires) {
+				delete(table, key)
+			}
+		}
+	}
+	for key, e := range entries.Hosts {
+		c.Hosts[key] = e
+	}
+	for key, e := range entries.Addrs {
+		c.Addrs[key] = e
+	}
+
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, b, 0600)
+}
+
+// Lists or empties the DNS cache
+func manageDNSCache(data []string) {
+	if len(data) != 1 {
+		fmt.Println("Usage: cjdcmd dnscache list|flush")
+		return
+	}
+	switch data[0] {
+	case "list":
+		c, err := readDNSCache()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		now := time.Now()
+		printEntries := func(table map[string]*dnsCacheEntry) {
+			keys := make([]string, 0, len(table))
+			for key := range table {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				e := table[key]
+				if now.After(e.Expires) {
+					continue
+				}
+				values := strings.Join(e.Values, ", ")
+				if values == "" {
+					values = "(not found)"
+				}
+				// Keys are the resolvers asked followed by the name
+				name, scope := key, ""
+				if parts := strings.SplitN(key, " ", 2); len(parts) == 2 {
+					scope, name = parts[0], parts[1]
+				}
+				fmt.Printf("%-40v %-40v expires in %-10v %v\n", name, values, e.Expires.Sub(now).Truncate(time.Second), scope)
+			}
+		}
+		printEntries(c.Hosts)
+		printEntries(c.Addrs)
+
+	case "flush":
+		file, err := dnsCacheFile()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err = os.Remove(file); err != nil && !os.IsNotExist(err) {
+			fmt.Println("Unable to flush the DNS cache:", err)
+			return
+		}
+		cacheLock.Lock()
+		cache, cacheNew = nil, nil
+		cacheLock.Unlock()
+		fmt.Println("Flushed", file)
+
+	default:
+		fmt.Printf("Unknown dnscache command '%v', use list or flush\n", data[0])
+	}
+}
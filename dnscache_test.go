@@ -0,0 +1,178 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Points the cache at a temporary directory and forgets anything loaded
+func testDNSCache(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	cache, cacheNew = nil, nil
+	t.Cleanup(func() { cache, cacheNew = nil, nil })
+	return filepath.Join(dir, "cjdcmd", "dns.json")
+}
+
+func TestAddrKey(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"FC5D:BAA5:61FC:6FFD:9554:67F0:E290:7535", "fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535"},
+		{"fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535", "fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535"},
+		{"fc00::1", "fc00:0000:0000:0000:0000:0000:0000:0001"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"example.hype", "example.hype"},
+	}
+	for _, test := range tests {
+		if got := addrKey(test.in); got != test.want {
+			t.Errorf("addrKey(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestDNSCacheKey(t *testing.T) {
+	oldMode, oldResolvers := ResolverMode, Resolvers
+	t.Cleanup(func() {
+		ResolverMode, Resolvers = oldMode, oldResolvers
+		meshResolverOnce, meshResolverList = sync.Once{}, nil
+	})
+
+	keys := make(map[string]string)
+	for _, test := range []struct {
+		mode      string
+		resolvers stringList
+	}{
+		{"system", nil},
+		{"mesh", stringList{"fc00::1"}},
+		{"mesh", stringList{"fc00::2"}},
+		{"both", stringList{"fc00::1"}},
+	} {
+		ResolverMode, Resolvers = test.mode, test.resolvers
+		meshResolverOnce, meshResolverList = sync.Once{}, nil
+		key := dnsCacheKey("a.hype")
+		desc := test.mode + " " + strings.Join(test.resolvers, ",")
+		if other, ok := keys[key]; ok {
+			t.Errorf("%v and %v share the cache key %q", other, desc, key)
+		}
+		keys[key] = desc
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	file := testDNSCache(t)
+
+	cacheAnswer(hostsTable, "a.hype", []string{"fc00::1"}, time.Minute)
+	cacheAnswer(hostsTable, "missing.hype", nil, time.Hour)
+	cacheAnswer(addrsTable, "fc00::2", []string{"b.hype"}, -time.Minute)
+
+	if values, ok := cachedAnswer(hostsTable, "a.hype"); !ok || !reflect.DeepEqual(values, []string{"fc00::1"}) {
+		t.Errorf("a.hype = %v, %v", values, ok)
+	}
+	if values, ok := cachedAnswer(hostsTable, "missing.hype"); !ok || len(values) != 0 {
+		t.Errorf("missing.hype = %v, %v, want a cached empty answer", values, ok)
+	}
+	if _, ok := cachedAnswer(addrsTable, "fc00::2"); ok {
+		t.Errorf("an expired answer was returned")
+	}
+	if e := cache.Hosts["missing.hype"]; e.Expires.After(time.Now().Add(dnsNegativeTTL)) {
+		t.Errorf("an empty answer is cached until %v, longer than %v", e.Expires, dnsNegativeTTL)
+	}
+
+	saveDNSCache()
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another run's answers are merged with these, and expired ones dropped
+	cache, cacheNew = nil, nil
+	cacheAnswer(addrsTable, "fc00::3", []string{"c.hype"}, time.Minute)
+	saveDNSCache()
+	c, err := readDNSCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a.hype", "missing.hype"} {
+		if c.Hosts[key] == nil {
+			t.Errorf("%v was lost", key)
+		}
+	}
+	if c.Addrs["fc00::3"] == nil {
+		t.Errorf("fc00::3 was not saved")
+	}
+	if c.Addrs["fc00::2"] != nil {
+		t.Errorf("the expired fc00::2 was saved")
+	}
+}
+
+func TestReadDNSCacheDamaged(t *testing.T) {
+	file := testDNSCache(t)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := readDNSCache()
+	if err == nil {
+		t.Errorf("a damaged cache was read without an error")
+	}
+	if c == nil || c.Hosts == nil || c.Addrs == nil {
+		t.Errorf("got %+v, want an empty cache", c)
+	}
+}
+
+func TestMkdirAllUser(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	if err := mkdirAllUser(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Fatalf("%v was not created: %v", dir, err)
+	}
+	if err := mkdirAllUser(dir, 0700); err != nil {
+		t.Errorf("creating an existing directory: %v", err)
+	}
+}
+
+func TestChownSudoUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root can give files away")
+	}
+	t.Setenv("SUDO_UID", "65534")
+	t.Setenv("SUDO_GID", "65534")
+	_, _, home, ok := sudoUser()
+	if !ok {
+		t.Skip("there is no user 65534")
+	}
+
+	// Files outside the sudo user's home directory are left alone
+	file := filepath.Join(t.TempDir(), "f")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := chownSudoUser(file); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(file)
+	if uid, _ := fileOwner(info); uid != 0 {
+		t.Errorf("%v outside %v was given to %d", file, home, uid)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGenConfAddr = "127.0.0.1"
+	defaultGenConfPort = 11234
+	defaultGenConfFile = "cjdroute.conf"
+)
+
+// Builds a complete config for the chosen template. Servers accept incoming
+// peers over UDP, clients only connect out and eth-only nodes auto-peer on
+// the local network without using UDP at all.
+func newConfig(template string) (conf map[string]interface{}, adminPass string, err error) {
+	switch template {
+	case "server", "client":
+	case "eth-only":
+		if GenConfEth == "" {
+			return nil, "", fmt.Errorf("The eth-only template needs a network card, use -eth")
+		}
+	default:
+		return nil, "", fmt.Errorf("Unknown template '%v', use server, client or eth-only", template)
+	}
+
+	priv, pub, err := generateKeys()
+	if err != nil {
+		return
+	}
+	if adminPass, _, err = newPassword(); err != nil {
+		return
+	}
+
+	passwords := []interface{}{}
+	if template != "client" {
+		pass, _, err := newPassword()
+		if err != nil {
+			return nil, "", err
+		}
+		passwords = append(passwords, map[string]interface{}{"password": pass})
+	}
+
+	interfaces := make(map[string]interface{})
+	if template != "eth-only" {
+		port := GenConfPort
+		if port == 0 {
+			n, err := randInt(65535 - 1024)
+			if err != nil {
+				return nil, "", err
+			}
+			port = n + 1024
+		}
+		interfaces["UDPInterface"] = []interface{}{map[string]interface{}{
+			"bind":      "0.0.0.0:" + strconv.Itoa(port),
+			"connectTo": map[string]interface{}{},
+		}}
+	}
+	if GenConfEth != "" {
+		if _, err := net.InterfaceByName(GenConfEth); err != nil {
+			fmt.Printf("Warning: there is no network card named '%v' on this machine\n", GenConfEth)
+		}
+		interfaces["ETHInterface"] = []interface{}{map[string]interface{}{
+			"bind":      GenConfEth,
+			"beacon":    2,
+			"connectTo": map[string]interface{}{},
+		}}
+	}
+
+	conf = map[string]interface{}{
+		"privateKey":          hex.EncodeToString(priv),
+		"publicKey":           pub.String(),
+		"ipv6":                padIPv6(pub.IP()),
+		"authorizedPasswords": passwords,
+		"admin": map[string]interface{}{
+			"bind":     net.JoinHostPort(defaultGenConfAddr, strconv.Itoa(defaultGenConfPort)),
+			"password": adminPass,
+		},
+		"interfaces": interfaces,
+		"router": map[string]interface{}{
+			"interface": map[string]interface{}{"type": "TUNInterface"},
+			"ipTunnel": map[string]interface{}{
+				"allowedConnections":  []interface{}{},
+				"outgoingConnections": []interface{}{},
+			},
+		},
+		"resetAfterInactivitySeconds": 100,
+		"security": []interface{}{
+			map[string]interface{}{"nofiles": 1},
+			map[string]interface{}{"setuser": "nobody"},
+		},
+		"logging":      map[string]interface{}{},
+		"noBackground": 0,
+	}
+	return
+}
+
+// Adds the peers from each peer file to the matching interface of conf
+func addPeerFiles(conf map[string]interface{}, files []string) {
+	for _, file := range files {
+		peers, err := readPeerFile(file)
+		if err != nil {
+			fmt.Printf("Skipping %v: %v\n", file, err)
+			continue
+		}
+		for _, p := range peers {
+			where := file + " " + p.Address
+			key, _ := p.Details["publicKey"].(string)
+			if err := validPublicKey(key); err != nil {
+				fmt.Printf("Skipping %v: %v\n", where, err)
+				continue
+			}
+			blocks := interfaceBlocks(conf, p.Interface)
+			if len(blocks) == 0 {
+				fmt.Printf("Skipping %v: the template has no %v\n", where, p.Interface)
+				continue
+			}
+			connectTo(blocks[0])[p.Address] = p.Details
+			fmt.Printf("Adding %v\n", where)
+		}
+	}
+}
+
+// Generates a new config from a template and saves it, along with a matching
+// .cjdnsadmin file, or profile with -add-profile, if -write-cjdnsadmin was
+// given. The .cjdnsadmin is the one in your home directory unless -cjdnsadmin
+// names another.
+func genConf() {
+	conf, adminPass, err := newConfig(GenConfTemplate)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if GenConfPeers != "" {
+		addPeerFiles(conf, strings.Split(GenConfPeers, ","))
+	}
+
+	if OutFile == "" {
+		if OutFile, err = filepath.Abs(defaultGenConfFile); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if fileExists(OutFile) {
+		fmt.Printf("Overwrite %v? [y/N]: ", OutFile)
+		if !gotYes(false) {
+			return
+		}
+	}
+
+	fmt.Printf("Saving configuration to: %v... ", OutFile)
+	if err := saveConfig(OutFile, conf, 0600); err != nil {
+		fmt.Println("\nError saving config:", err)
+		return
+	}
+	fmt.Printf("Saved\n")
+	fmt.Println("Your new IPv6 address is", conf["ipv6"])
+
+	if GenConfAdmin {
+		adminFile := userCjdnsadmin
+		if adminFile == "" {
+			if adminFile, err = homeCjdnsadmin(); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		adminOut := &admin.CjdnsAdminConfig{
+			Addr:     defaultGenConfAddr,
+			Port:     defaultGenConfPort,
+			Password: adminPass,
+			Config:   OutFile,
+		}
+		if AddProfile != "" {
+			addCjdnsadminProfile(adminFile, AddProfile, adminOut)
+		} else {
+			saveCjdnsadmin(adminFile, adminOut)
+		}
+	}
+	if GenConfTemplate != "client" {
+		fmt.Println("Use `cjdcmd peercard` to share your details with new peers")
+	}
+}
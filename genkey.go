@@ -0,0 +1,132 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/inhies/go-cjdns/key"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prints a keypair and its address the way they appear in cjdroute.conf
+func printIdentity(priv []byte, pub *key.Public) {
+	if priv != nil {
+		fmt.Printf("\"privateKey\": \"%v\",\n", hex.EncodeToString(priv))
+	}
+	fmt.Printf("\"publicKey\": \"%v\",\n", pub)
+	fmt.Printf("\"ipv6\": \"%v\"\n", padIPv6(pub.IP()))
+}
+
+// Generates a new keypair, searching for one whose address starts with the
+// prefix given by -vanity if it was set
+func genKey() {
+	if Vanity == "" {
+		priv, pub, err := generateKeys()
+		if err != nil {
+			fmt.Println("Unable to generate keys:", err)
+			return
+		}
+		printIdentity(priv, pub)
+		return
+	}
+
+	prefix := strings.ToLower(strings.Replace(Vanity, ":", "", -1))
+	if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil ||
+		!strings.HasPrefix(prefix, "fc") || len(prefix) > 32 {
+		fmt.Println("The vanity prefix must be part of an IPv6 address starting with fc")
+		return
+	}
+
+	priv, pub := vanitySearch(prefix)
+	printIdentity(priv, pub)
+}
+
+// Searches for a keypair whose address, as hex without colons, starts with
+// prefix using every CPU, printing progress to stderr as it goes
+func vanitySearch(prefix string) (priv []byte, pub *key.Public) {
+	// Only 1 in 256 keys give an fc address, then each further hex digit
+	// must match by chance
+	expected := 256 * math.Pow(16, float64(len(prefix)-2))
+
+	var tried uint64
+	var once sync.Once
+	type keypair struct {
+		priv []byte
+		pub  *key.Public
+	}
+	found := make(chan keypair, 1)
+	done := make(chan bool)
+
+	workers := runtime.NumCPU()
+	runtime.GOMAXPROCS(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			k := make([]byte, 32)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := rand.Read(k); err != nil {
+					fmt.Fprintln(os.Stderr, "Unable to generate keys:", err)
+					os.Exit(1)
+				}
+				atomic.AddUint64(&tried, 1)
+				p, err := privateToPublic(k)
+				if err != nil {
+					continue
+				}
+				if strings.HasPrefix(hex.EncodeToString(p.IP()), prefix) {
+					once.Do(func() {
+						found <- keypair{append([]byte(nil), k...), p}
+						close(done)
+					})
+					return
+				}
+			}
+		}()
+	}
+
+	fmt.Fprintf(os.Stderr, "Searching for %v using %d CPUs, expecting to try about %.0f keys\n",
+		Vanity, workers, expected)
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case keys := <-found:
+			fmt.Fprintf(os.Stderr, "\nFound after %d keys in %v\n",
+				atomic.LoadUint64(&tried), time.Since(start).Truncate(time.Second))
+			return keys.priv, keys.pub
+		case <-ticker.C:
+			n := atomic.LoadUint64(&tried)
+			rate := float64(n) / time.Since(start).Seconds()
+			remaining := time.Duration((expected - float64(n)) / rate * float64(time.Second))
+			if remaining < 0 {
+				remaining = 0
+			}
+			fmt.Fprintf(os.Stderr, "\rTried %d keys (%.0f/s), expected time remaining %v        ",
+				n, rate, remaining.Truncate(time.Second))
+		}
+	}
+}
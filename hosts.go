@@ -0,0 +1,399 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/inhies/go-cjdns/key"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A line of a hosts file. Only lines giving names to a cjdns address have an
+// IP; the rest are comments, blank lines or other addresses. Raw is the line
+// as it was read, and is written back as long as the line hasn't been
+// changed.
+type hostsLine struct {
+	IP      string
+	Names   []string
+	Comment string
+	Raw     string
+}
+
+// cjdcmd's own hosts file, in the same format as /etc/hosts. Only cjdns
+// addresses are looked up in it.
+type hostsDB struct {
+	Lines []*hostsLine
+}
+
+var (
+	localHostsDB   *hostsDB
+	localHostsOnce sync.Once
+)
+
+// Returns the hosts file, ~/.config/cjdcmd/hosts unless $XDG_CONFIG_HOME is
+// set
+func hostsFile() (string, error) {
+	home, err := userHome()
+	if err != nil {
+		return "", err
+	}
+	return xdgConfigFile(home, "hosts"), nil
+}
+
+// Reads a hosts file. An empty database is returned if the file doesn't
+// exist.
+func readHosts(file string) (*hostsDB, error) {
+	h := new(hostsDB)
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		l := &hostsLine{Raw: scanner.Text()}
+		h.Lines = append(h.Lines, l)
+
+		entry := l.Raw
+		if i := strings.Index(entry, "#"); i >= 0 {
+			entry, l.Comment = entry[:i], entry[i:]
+		}
+		fields := strings.Fields(entry)
+		if len(fields) < 2 {
+			continue
+		}
+		if ip := net.ParseIP(fields[0]); ip != nil && ip.To4() == nil && ip[0] == 0xfc {
+			l.IP, l.Names = padIPv6(ip), fields[1:]
+		}
+	}
+	return h, scanner.Err()
+}
+
+// Writes the database back to file. Lines that were changed are written
+// out again, keeping any comment on the end, and those left without a name
+// are dropped.
+func (h *hostsDB) write(file string) error {
+	if err := mkdirAllUser(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	for _, l := range h.Lines {
+		switch {
+		case l.Raw != "" || l.IP == "":
+			fmt.Fprintln(&b, l.Raw)
+		case len(l.Names) > 0 && l.Comment != "":
+			fmt.Fprintf(&b, "%v\t%v %v\n", l.IP, strings.Join(l.Names, " "), l.Comment)
+		case len(l.Names) > 0:
+			fmt.Fprintf(&b, "%v\t%v\n", l.IP, strings.Join(l.Names, " "))
+		}
+	}
+	return writeFileAtomic(file, b.Bytes(), 0644)
+}
+
+// Returns the first name for an address
+func (h *hostsDB) name(ip string) string {
+	for _, l := range h.Lines {
+		if l.IP == ip && len(l.Names) > 0 {
+			return l.Names[0]
+		}
+	}
+	return ""
+}
+
+// Returns the addresses a name is given to
+func (h *hostsDB) addrs(name string) (ips []string) {
+	for _, l := range h.Lines {
+		for _, n := range l.Names {
+			if strings.EqualFold(n, name) {
+				ips = append(ips, l.IP)
+				break
+			}
+		}
+	}
+	return
+}
+
+// Gives name to ip, taking it away from any other address. Returns false if
+// it was already there.
+func (h *hostsDB) add(name, ip string) bool {
+	if addrs := h.addrs(name); len(addrs) == 1 && addrs[0] == ip {
+		return false
+	}
+	h.remove(name)
+	for _, l := range h.Lines {
+		if l.IP == ip {
+			l.Names = append(l.Names, name)
+			l.Raw = ""
+			return true
+		}
+	}
+	h.Lines = append(h.Lines, &hostsLine{IP: ip, Names: []string{name}})
+	return true
+}
+
+// Removes a name, or every name for an address. Returns false if there was
+// nothing to remove.
+func (h *hostsDB) remove(nameOrIP string) (removed bool) {
+	if ip := net.ParseIP(nameOrIP); ip != nil {
+		nameOrIP = padIPv6(ip)
+	}
+	for _, l := range h.Lines {
+		if l.IP == "" {
+			continue
+		}
+		if l.IP == nameOrIP {
+			l.Names, l.Raw = nil, ""
+			removed = true
+			continue
+		}
+		var keep []string
+		for _, n := range l.Names {
+			if strings.EqualFold(n, nameOrIP) {
+				removed = true
+				l.Raw = ""
+			} else {
+				keep = append(keep, n)
+			}
+		}
+		l.Names = keep
+	}
+	return
+}
+
+// Returns the hosts database for looking names up in, reading it the first
+// time it is needed
+func localHosts() *hostsDB {
+	localHostsOnce.Do(func() {
+		localHostsDB = new(hostsDB)
+		file, err := hostsFile()
+		if err != nil {
+			return
+		}
+		if h, err := readHosts(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read %v: %v\n", file, err)
+		} else {
+			localHostsDB = h
+		}
+	})
+	return localHostsDB
+}
+
+// Returns the cjdns address for an IP address or public key
+func hostsAddr(s string) (string, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil || ip[0] != 0xfc {
+			return "", fmt.Errorf("'%v' is not a cjdns address", s)
+		}
+		return padIPv6(ip), nil
+	}
+	if err := validPublicKey(s); err != nil {
+		return "", fmt.Errorf("'%v' is not a cjdns address or public key", s)
+	}
+	pub, _ := key.DecodePublic(s)
+	return padIPv6(pub.IP()), nil
+}
+
+// Returns the names given to peers in the connectTo sections of a config,
+// mapped to their addresses. Peers without a name, or with one that can't be
+// used as a hostname, are left out.
+func peerHosts(conf map[string]interface{}) map[string]string {
+	hosts := make(map[string]string)
+	for _, iface := range interfaceNames(conf) {
+		for _, block := range interfaceBlocks(conf, iface) {
+			for _, p := range connectTo(block) {
+				peer, ok := p.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := peer["name"].(string)
+				key, _ := peer["publicKey"].(string)
+				if !validHost(name) {
+					continue
+				}
+				if ip, err := hostsAddr(key); err == nil {
+					hosts[name] = ip
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+// Adds the names of the peers in a config to the hosts file. Names already in
+// it are left alone, so ones the user set themselves are never replaced.
+func addPeerHosts(conf map[string]interface{}) {
+	peers := peerHosts(conf)
+	if len(peers) == 0 {
+		return
+	}
+	file, err := hostsFile()
+	if err != nil {
+		return
+	}
+	h, err := readHosts(file)
+	if err != nil {
+		fmt.Printf("Unable to read %v: %v\n", file, err)
+		return
+	}
+	added := 0
+	for name, ip := range peers {
+		if len(h.addrs(name)) == 0 && h.add(name, ip) {
+			added++
+		}
+	}
+	if added == 0 {
+		return
+	}
+	if err := h.write(file); err != nil {
+		fmt.Printf("Unable to save %v: %v\n", file, err)
+		return
+	}
+	fmt.Printf("Added %d peer names to %v\n", added, file)
+}
+
+// Reads the names to import from a hosts file or a cjdroute.conf, or from the
+// config given with --file if no file is named
+func importHosts(data []string) (map[string]string, error) {
+	if len(data) == 0 {
+		if err := setConfigFile(); err != nil {
+			return nil, err
+		}
+		data = []string{File}
+	}
+
+	hosts := make(map[string]string)
+	for _, file := range data {
+		if conf, err := loadExtConfig(file); err == nil {
+			for name, ip := range peerHosts(conf) {
+				hosts[name] = ip
+			}
+			continue
+		}
+		h, err := readHosts(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range h.Lines {
+			for _, name := range l.Names {
+				hosts[name] = l.IP
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// Lists, adds, removes or imports names in cjdcmd's hosts file
+func manageHosts(data []string) {
+	file, err := hostsFile()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	h, err := readHosts(file)
+	if err != nil {
+		fmt.Printf("Unable to read %v: %v\n", file, err)
+		return
+	}
+
+	if len(data) == 0 {
+		data = []string{"list"}
+	}
+	switch data[0] {
+	case "list":
+		for _, l := range h.Lines {
+			if l.IP != "" && len(l.Names) > 0 {
+				fmt.Printf("%-40v %v\n", l.IP, strings.Join(l.Names, " "))
+			}
+		}
+		return
+
+	case "add":
+		if len(data) != 3 {
+			fmt.Println("Usage: cjdcmd hosts add <name> <ip|public key>")
+			return
+		}
+		if !validHost(data[1]) {
+			fmt.Printf("Invalid hostname '%v'\n", data[1])
+			return
+		}
+		ip, err := hostsAddr(data[2])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !h.add(data[1], ip) {
+			fmt.Printf("%v is already %v\n", data[1], ip)
+			return
+		}
+		fmt.Printf("Added %v %v\n", ip, data[1])
+
+	case "remove":
+		if len(data) != 2 {
+			fmt.Println("Usage: cjdcmd hosts remove <name|ip>")
+			return
+		}
+		if !h.remove(data[1]) {
+			fmt.Printf("%v is not in %v\n", data[1], file)
+			return
+		}
+		fmt.Println("Removed", data[1])
+
+	case "import":
+		hosts, err := importHosts(data[1:])
+		if err != nil {
+			fmt.Println("Unable to import hosts:", err)
+			return
+		}
+		names := make([]string, 0, len(hosts))
+		for name := range hosts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		added := 0
+		for _, name := range names {
+			if addrs := h.addrs(name); len(addrs) > 0 && !HostsForce {
+				if addrs[0] != hosts[name] {
+					fmt.Printf("Skipping %v: already %v, use -force to replace it\n", name, addrs[0])
+				}
+				continue
+			}
+			if h.add(name, hosts[name]) {
+				fmt.Printf("Added %v %v\n", hosts[name], name)
+				added++
+			}
+		}
+		if added == 0 {
+			fmt.Println("No new names to import")
+			return
+		}
+
+	default:
+		fmt.Printf("Unknown hosts command '%v', use list, add, remove or import\n", data[0])
+		return
+	}
+
+	if err := h.write(file); err != nil {
+		fmt.Printf("Unable to save %v: %v\n", file, err)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testHosts = `# cjdcmd hosts
+127.0.0.1	localhost
+fc00::1	alice al # Alice's server
+
+fc00::2 bob
+fd00::3	notcjdns
+`
+
+// Writes a hosts file and reads it back in
+func testHostsDB(t *testing.T, content string) (*hostsDB, string) {
+	file := filepath.Join(t.TempDir(), "hosts")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := readHosts(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, file
+}
+
+// Writes the database and returns what ends up in the file
+func writeTestHosts(t *testing.T, h *hostsDB, file string) string {
+	if err := h.write(file); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReadHosts(t *testing.T) {
+	h, _ := testHostsDB(t, testHosts)
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"alice", []string{"fc00:0000:0000:0000:0000:0000:0000:0001"}},
+		{"AL", []string{"fc00:0000:0000:0000:0000:0000:0000:0001"}},
+		{"bob", []string{"fc00:0000:0000:0000:0000:0000:0000:0002"}},
+		{"localhost", nil},
+		{"notcjdns", nil},
+		{"Alice's", nil},
+	}
+	for _, test := range tests {
+		if got := h.addrs(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("addrs(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+	if got := h.name("fc00:0000:0000:0000:0000:0000:0000:0001"); got != "alice" {
+		t.Errorf("name(fc00::1) = %q, want alice", got)
+	}
+}
+
+func TestHostsWriteUntouched(t *testing.T) {
+	h, file := testHostsDB(t, testHosts)
+	if got := writeTestHosts(t, h, file); got != testHosts {
+		t.Errorf("an unchanged hosts file was written as\n%v\nwant\n%v", got, testHosts)
+	}
+}
+
+func TestHostsEdit(t *testing.T) {
+	h, file := testHostsDB(t, testHosts)
+	if !h.add("carol", "fc00:0000:0000:0000:0000:0000:0000:0001") {
+		t.Errorf("adding carol did nothing")
+	}
+	if h.add("carol", "fc00:0000:0000:0000:0000:0000:0000:0001") {
+		t.Errorf("adding carol twice changed something")
+	}
+	if !h.remove("bob") {
+		t.Errorf("removing bob did nothing")
+	}
+	if h.remove("nobody") {
+		t.Errorf("removing a missing name changed something")
+	}
+	h.add("dave", "fc00:0000:0000:0000:0000:0000:0000:0004")
+
+	want := `# cjdcmd hosts
+127.0.0.1	localhost
+fc00:0000:0000:0000:0000:0000:0000:0001	alice al carol # Alice's server
+
+fd00::3	notcjdns
+fc00:0000:0000:0000:0000:0000:0000:0004	dave
+`
+	if got := writeTestHosts(t, h, file); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestHostsMoveName(t *testing.T) {
+	h, _ := testHostsDB(t, testHosts)
+	h.add("al", "fc00:0000:0000:0000:0000:0000:0000:0002")
+	if got := h.addrs("al"); !reflect.DeepEqual(got, []string{"fc00:0000:0000:0000:0000:0000:0000:0002"}) {
+		t.Errorf("al is %q after moving it to fc00::2", got)
+	}
+}
+
+func TestHostsAddr(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535", "fc5d:baa5:61fc:6ffd:9554:67f0:e290:7535", false},
+		{"fc00::1", "fc00:0000:0000:0000:0000:0000:0000:0001", false},
+		{"fd00::1", "", true},
+		{"10.0.0.1", "", true},
+		{"not a key", "", true},
+	}
+	for _, test := range tests {
+		got, err := hostsAddr(test.in)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("hostsAddr(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+	}
+	if ip, err := hostsAddr("r6jzx210usqbgnm3pdtm1z6btd14pvdtkn5j8qnpgqzknpggkuw0.k"); err != nil || ip[:2] != "fc" {
+		t.Errorf("hostsAddr(key) = %q, %v", ip, err)
+	}
+}
+
+func TestHostsImport(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	file := filepath.Join(dir, "cjdcmd", "hosts")
+	h := &hostsDB{}
+	h.add("alice", "fc00:0000:0000:0000:0000:0000:0000:0001")
+	if err := h.write(file); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, []byte("fc00::9 alice\nfc00::2 bob\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, force := range []bool{false, true} {
+		HostsForce = force
+		manageHosts([]string{"import", other})
+		HostsForce = false
+		h, err := readHosts(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "fc00:0000:0000:0000:0000:0000:0000:0001"
+		if force {
+			want = "fc00:0000:0000:0000:0000:0000:0000:0009"
+		}
+		if got := h.addrs("alice"); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("with force %v alice is %q, want %v", force, got, want)
+		}
+		if got := h.addrs("bob"); len(got) != 1 {
+			t.Errorf("with force %v bob is %q", force, got)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"github.com/inhies/go-cjdns/key"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A peer found in a public peers directory
+type importedPeer struct {
+	File      string
+	Interface string
+	Address   string
+	Details   map[string]interface{}
+}
+
+// Reads every peer file under dir, or only those in the region directory if
+// region is set. Peer files hold one or more connectTo entries and end in
+// .k or .json.
+func findPeers(dir, region string) (peers []*importedPeer, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if info.IsDir() || (ext != ".k" && ext != ".json") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		if region != "" && !inRegion(rel, region) {
+			return nil
+		}
+
+		found, err := readPeerFile(path)
+		if err != nil {
+			fmt.Printf("Skipping %v: %v\n", rel, err)
+			return nil
+		}
+		for _, p := range found {
+			p.File = rel
+		}
+		peers = append(peers, found...)
+		return nil
+	})
+	return
+}
+
+// Reads the connectTo entries from a peer file
+func readPeerFile(path string) (peers []*importedPeer, err error) {
+	var entries map[string]interface{}
+	if err = readJSONFile(path, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, addr := range sortedKeys(entries) {
+		details, ok := entries[addr].(map[string]interface{})
+		if !ok {
+			fmt.Printf("Skipping %v in %v: not a connectTo entry\n", addr, path)
+			continue
+		}
+		iface := "UDPInterface"
+		if _, err := net.ParseMAC(addr); err == nil {
+			iface = "ETHInterface"
+		}
+		peers = append(peers, &importedPeer{path, iface, addr, details})
+	}
+	return
+}
+
+// Returns true if any directory in the relative path rel is named region
+func inRegion(rel, region string) bool {
+	for _, dir := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if strings.EqualFold(dir, region) {
+			return true
+		}
+	}
+	return false
+}
+
+// Asks which settings block of an interface to use if there is more than one
+func selectBlock(conf map[string]interface{}, iface, prompt string) map[string]interface{} {
+	blocks := interfaceBlocks(conf, iface)
+	if len(blocks) == 0 {
+		return nil
+	} else if len(blocks) == 1 {
+		return blocks[0]
+	}
+
+	fmt.Printf("You have multiple '%v' options to choose from, enter yes or no, or press enter for the default option\n", iface)
+	for {
+		for _, block := range blocks {
+			fmt.Printf("%v '%v %v' [Y/n]: ", prompt, iface, block["bind"])
+			if gotYes(true) {
+				return block
+			}
+		}
+		fmt.Println("You must select an interface to add to!")
+	}
+}
+
+// Adds peers from a directory of peer files to the config file, returning
+// false if they couldn't be added
+func importPeers(data []string) bool {
+	if len(data) != 1 {
+		fmt.Println("You must specify the directory to import peers from")
+		return false
+	}
+
+	found, err := findPeers(data[0], ImportRegion)
+	if err != nil {
+		fmt.Println("Error reading peers:", err)
+		return false
+	}
+	fmt.Printf("Found %d peers\n", len(found))
+
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	unlock, err := lockConfigEdit()
+	if err != nil {
+		fmt.Println("Unable to lock config:", err)
+		return false
+	}
+	defer unlock()
+
+	fmt.Printf("Loading configuration from: %v... ", File)
+	conf, err := loadExtConfig(File)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return false
+	}
+	fmt.Printf("Loaded\n")
+
+	// Remember who we already peer with
+	existing := make(map[string]bool)
+	for _, name := range interfaceNames(conf) {
+		for _, block := range interfaceBlocks(conf, name) {
+			peers := connectTo(block)
+			for addr, p := range peers {
+				existing[addr] = true
+				if peer, ok := p.(map[string]interface{}); ok {
+					if key, ok := peer["publicKey"].(string); ok {
+						existing[key] = true
+					}
+				}
+			}
+		}
+	}
+
+	var user *admin.Conn
+	if ImportPing {
+		if user, err = adminConnect(); err != nil {
+			return false
+		}
+	}
+
+	blocks := make(map[string]map[string]interface{})
+	added := 0
+	for _, p := range found {
+		if ImportMax > 0 && added >= ImportMax {
+			break
+		}
+		where := p.File + " " + p.Address
+		pubKey, _ := p.Details["publicKey"].(string)
+		if existing[p.Address] || existing[pubKey] {
+			fmt.Printf("Skipping %v: already in your config\n", where)
+			continue
+		}
+		if err := validPublicKey(pubKey); err != nil {
+			fmt.Printf("Skipping %v: %v\n", where, err)
+			continue
+		}
+		if ImportResolve && p.Interface == "UDPInterface" {
+			if p.Address, err = resolvePeerAddress(p.Address); err != nil {
+				fmt.Printf("Skipping %v: %v\n", where, err)
+				continue
+			}
+			if existing[p.Address] {
+				fmt.Printf("Skipping %v: already in your config as %v\n", where, p.Address)
+				continue
+			}
+		}
+		if err := validPeerAddress(p.Interface, p.Address); err != nil {
+			fmt.Printf("Skipping %v: %v\n", where, err)
+			continue
+		}
+		if user != nil {
+			pub, _ := key.DecodePublic(pubKey)
+			ping := &Ping{Target: padIPv6(pub.IP())}
+			if err := pingNode(user, ping); err != nil || ping.Success == 0 {
+				fmt.Printf("Skipping %v: no response to ping\n", where)
+				continue
+			}
+		}
+
+		block, ok := blocks[p.Interface]
+		if !ok {
+			block = selectBlock(conf, p.Interface, "Add peers to")
+			blocks[p.Interface] = block
+		}
+		if block == nil {
+			fmt.Printf("Skipping %v: your config has no %v\n", where, p.Interface)
+			continue
+		}
+		if connectTo(block) == nil {
+			block["connectTo"] = make(map[string]interface{})
+		}
+		connectTo(block)[p.Address] = p.Details
+		existing[p.Address], existing[pubKey] = true, true
+		fmt.Printf("Adding %v\n", where)
+		added++
+	}
+
+	if added == 0 {
+		fmt.Println("No new peers to add")
+		return true
+	}
+	if !writeConfig(conf) {
+		return false
+	}
+	addPeerHosts(conf)
+	return true
+}
+
+// Replaces a hostname in a host:port address with its IP address
+func resolvePeerAddress(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, err
+	}
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no addresses found for %v", host)
+	}
+	return net.JoinHostPort(ips[0], port), nil
+}
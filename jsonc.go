@@ -0,0 +1,183 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// How many lines before and after an error are shown
+const jsonContextLines = 2
+
+// jsonError describes a problem found in a JSON file, where it happened, and
+// the lines surrounding it.
+type jsonError struct {
+	File    string
+	Offset  int64
+	Line    int
+	Column  int
+	Msg     string
+	Context string
+}
+
+func (e *jsonError) Error() string {
+	loc := fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+	if e.File != "" {
+		loc = e.File + ": " + loc
+	}
+	if e.Context == "" {
+		return loc + ": " + e.Msg
+	}
+	return loc + ": " + e.Msg + "\n" + e.Context
+}
+
+// newJSONError builds a jsonError for the byte at offset in b
+func newJSONError(file string, b []byte, offset int64, msg string) *jsonError {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	start := bytes.LastIndexByte(b[:offset], '\n') + 1
+	e := &jsonError{
+		File:   file,
+		Offset: offset,
+		Line:   bytes.Count(b[:offset], []byte{'\n'}) + 1,
+		Column: utf8.RuneCount(b[start:offset]) + 1,
+		Msg:    msg,
+	}
+
+	lines := strings.Split(string(b), "\n")
+	first := e.Line - 1 - jsonContextLines
+	if first < 0 {
+		first = 0
+	}
+	last := e.Line - 1 + jsonContextLines
+	if last > len(lines)-1 {
+		last = len(lines) - 1
+	}
+	var ctx []string
+	for i := first; i <= last; i++ {
+		ctx = append(ctx, fmt.Sprintf("%5d | %s", i+1, lines[i]))
+		if i == e.Line-1 {
+			// Point at the offending column
+			pad := strings.Map(func(r rune) rune {
+				if r == '\t' {
+					return r
+				}
+				return ' '
+			}, string(b[start:offset]))
+			ctx = append(ctx, "      | "+pad+"^")
+		}
+	}
+	e.Context = strings.Join(ctx, "\n")
+	return e
+}
+
+// stripComments removes // and /* */ comments from b while leaving string
+// literals untouched. Comments are replaced with spaces and their newlines
+// are kept, so offsets into the result match offsets into b.
+func stripComments(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	copy(out, b)
+
+	inString := false
+	stringStart := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if inString {
+			switch c {
+			case '\\':
+				// Skip whatever is escaped, including a quote
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			stringStart = i
+
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			for ; i < len(b) && b[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, newJSONError("", b, int64(i), "unterminated comment")
+			}
+			end += i + 4
+			for ; i < end; i++ {
+				if b[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+	if inString {
+		return nil, newJSONError("", b, int64(stringStart), "unterminated string")
+	}
+	return out, nil
+}
+
+// parseJSON strips the comments from b and decodes it in to v. Syntax and
+// type errors are returned as a *jsonError pointing at the original input.
+func parseJSON(file string, b []byte, v interface{}) error {
+	raw, err := stripComments(b)
+	if err != nil {
+		if e, ok := err.(*jsonError); ok {
+			e.File = file
+		}
+		return err
+	}
+
+	err = json.Unmarshal(raw, v)
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		// Offset is the number of bytes read when the error was noticed
+		return newJSONError(file, b, e.Offset-1, e.Error())
+	case *json.UnmarshalTypeError:
+		msg := fmt.Sprintf("expected %v but found %v", e.Type, e.Value)
+		return newJSONError(file, b, e.Offset-1, msg)
+	}
+	return err
+}
+
+// readJSONFile reads a JSON file which may contain comments and decodes it in
+// to v
+func readJSONFile(file string, v interface{}) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return parseJSON(file, b, v)
+}
+
+// loadExtConfig loads every setting in a cjdroute.conf file
+func loadExtConfig(file string) (conf map[string]interface{}, err error) {
+	err = readJSONFile(file, &conf)
+	return
+}
@@ -0,0 +1,185 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"none", `{"a": 1}`, `{"a": 1}`},
+		{"line", "{\"a\": 1 // one\n}", "{\"a\": 1       \n}"},
+		{"block", `{"a": /* x */ 1}`, `{"a":         1}`},
+		{"block keeps newlines", "{/* a\nb */}", "{    \n    }"},
+		{"slashes in string", `{"a": "http://x/*y*/"}`, `{"a": "http://x/*y*/"}`},
+		{"escaped quote", `{"a": "\"//"} // c`, `{"a": "\"//"}     `},
+		{"comment at end", `1 //`, `1   `},
+	}
+	for _, tt := range tests {
+		got, err := stripComments([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripCommentsErrors(t *testing.T) {
+	tests := []struct {
+		name, in     string
+		line, column int
+		msg          string
+	}{
+		{"unterminated comment", "{\n  /* open\n}", 2, 3, "unterminated comment"},
+		{"unterminated string", "{\"a\": \"b}", 1, 7, "unterminated string"},
+	}
+	for _, tt := range tests {
+		_, err := stripComments([]byte(tt.in))
+		e, ok := err.(*jsonError)
+		if !ok {
+			t.Errorf("%v: got %v, want a *jsonError", tt.name, err)
+			continue
+		}
+		if e.Line != tt.line || e.Column != tt.column || e.Msg != tt.msg {
+			t.Errorf("%v: got %v:%v %q, want %v:%v %q", tt.name, e.Line, e.Column, e.Msg, tt.line, tt.column, tt.msg)
+		}
+	}
+}
+
+func TestParseJSONErrors(t *testing.T) {
+	tests := []struct {
+		name, in     string
+		line, column int
+	}{
+		{"missing comma", "{\n\t\"a\": 1\n\t\"b\": 2\n}", 3, 2},
+		{"trailing comma", "{\"a\": 1,}", 1, 9},
+		{"after comment", "{ // c\n  \"a\": ]\n}", 2, 8},
+		{"wrong type", "{\"a\": \"x\"}", 1, 9},
+	}
+	for _, tt := range tests {
+		var v struct{ A int }
+		err := parseJSON("test.conf", []byte(tt.in), &v)
+		e, ok := err.(*jsonError)
+		if !ok {
+			t.Errorf("%v: got %v, want a *jsonError", tt.name, err)
+			continue
+		}
+		if e.Line != tt.line || e.Column != tt.column {
+			t.Errorf("%v: got line %v column %v, want line %v column %v", tt.name, e.Line, e.Column, tt.line, tt.column)
+		}
+		if !strings.HasPrefix(e.Error(), "test.conf: ") {
+			t.Errorf("%v: error %q doesn't name the file", tt.name, e.Error())
+		}
+	}
+}
+
+func TestParseJSONComments(t *testing.T) {
+	in := `{
+		// The admin section
+		"admin": {"bind": "127.0.0.1:11234", /* inline */ "password": "p//w"}
+	}`
+	var v map[string]map[string]string
+	if err := parseJSON("", []byte(in), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v["admin"]["password"] != "p//w" || v["admin"]["bind"] != "127.0.0.1:11234" {
+		t.Errorf("got %v", v)
+	}
+}
+
+func TestParsePeerDetails(t *testing.T) {
+	peer, err := parsePeerDetails(`"1.2.3.4:5": {"password": "x"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := peer["1.2.3.4:5"]; !ok {
+		t.Errorf("got %v", peer)
+	}
+
+	// The column must count from the start of the input, not the brace
+	// added in front of it
+	_, err = parsePeerDetails(`"1.2.3.4:5": {"password" "x"}`)
+	e, ok := err.(*jsonError)
+	if !ok {
+		t.Fatalf("got %v, want a *jsonError", err)
+	}
+	if e.Line != 1 || e.Column != 26 {
+		t.Errorf("got line %v column %v, want line 1 column 26", e.Line, e.Column)
+	}
+
+	if _, err = parsePeerDetails(`"1.2.3.4:5": "x"`); err == nil {
+		t.Errorf("details that aren't an object were accepted")
+	}
+}
+
+func FuzzStripComments(f *testing.F) {
+	for _, seed := range []string{`{"a": 1}`, "// x\n{}", `{"a": "/*"} /* b */`, `"\"`, "/*"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		out, err := stripComments(b)
+		if err != nil {
+			if _, ok := err.(*jsonError); !ok {
+				t.Fatalf("error is a %T, not a *jsonError", err)
+			}
+			return
+		}
+		// Offsets must still line up with the input
+		if len(out) != len(b) {
+			t.Fatalf("length changed from %v to %v", len(b), len(out))
+		}
+		for i := range b {
+			if b[i] == '\n' && out[i] != '\n' {
+				t.Fatalf("newline at %v was removed", i)
+			}
+		}
+		if !bytes.Contains(b, []byte("/")) && !bytes.Equal(out, b) {
+			t.Fatalf("input without comments was changed")
+		}
+	})
+}
+
+func FuzzParseJSON(f *testing.F) {
+	for _, seed := range []string{`{"a": 1}`, "{\n// c\n\"a\": [1, 2,]}", `{"a": /* */ "b"`, "\xff{"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var v interface{}
+		err := parseJSON("", b, &v)
+		if e, ok := err.(*jsonError); ok {
+			if e.Line < 1 || e.Column < 1 {
+				t.Fatalf("bad location %v:%v", e.Line, e.Column)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		// Anything accepted must be the same as the input without comments
+		stripped, _ := stripComments(b)
+		var want interface{}
+		if json.Unmarshal(stripped, &want) != nil {
+			t.Fatalf("accepted input that isn't valid once comments are removed")
+		}
+	})
+}
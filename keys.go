@@ -0,0 +1,58 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/inhies/go-cjdns/key"
+)
+
+// Decodes a hex encoded private key as found in cjdroute.conf
+func decodePrivateKey(s string) ([]byte, error) {
+	priv, err := hex.DecodeString(s)
+	if err != nil || len(priv) != 32 {
+		return nil, fmt.Errorf("Invalid private key, expected 64 hexadecimal characters")
+	}
+	return priv, nil
+}
+
+// Derives the public key for a raw private key
+func privateToPublic(priv []byte) (*key.Public, error) {
+	k, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	pub := new(key.Public)
+	copy(pub[:], k.PublicKey().Bytes())
+	return pub, nil
+}
+
+// Generates a new keypair whose address is a valid cjdns address
+func generateKeys() (priv []byte, pub *key.Public, err error) {
+	priv = make([]byte, 32)
+	for {
+		if _, err = rand.Read(priv); err != nil {
+			return nil, nil, err
+		}
+		if pub, err = privateToPublic(priv); err != nil {
+			return nil, nil, err
+		}
+		if pub.IP()[0] == 0xfc {
+			return
+		}
+	}
+}
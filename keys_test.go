@@ -0,0 +1,54 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"github.com/inhies/go-cjdns/key"
+	"testing"
+)
+
+func TestGenerateKeys(t *testing.T) {
+	priv, pub, err := generateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.IP()[0] != 0xfc {
+		t.Errorf("generated a key for %v, which isn't a cjdns address", pub.IP())
+	}
+	again, err := privateToPublic(priv)
+	if err != nil || *again != *pub {
+		t.Errorf("privateToPublic gave %v, %v, want %v", again, err, pub)
+	}
+	decoded, err := key.DecodePublic(pub.String())
+	if err != nil || *decoded != *pub {
+		t.Errorf("%v decoded to %v, %v", pub, decoded, err)
+	}
+}
+
+func TestDecodePrivateKey(t *testing.T) {
+	tests := []struct {
+		in  string
+		err bool
+	}{
+		{"751d3db85b848e9ab9bd4d76dd8d0c1c1b07ccfe5c8b4b0d7de41e0fd2c6b8a1", false},
+		{"751d3db85b848e9ab9bd4d76dd8d0c1c", true},
+		{"zz1d3db85b848e9ab9bd4d76dd8d0c1c1b07ccfe5c8b4b0d7de41e0fd2c6b8a1", true},
+		{"", true},
+	}
+	for _, test := range tests {
+		if _, err := decodePrivateKey(test.in); (err != nil) != test.err {
+			t.Errorf("decodePrivateKey(%q) error = %v", test.in, err)
+		}
+	}
+}
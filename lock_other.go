@@ -0,0 +1,53 @@
+//go:build !windows && !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// How long to wait for another cjdcmd to finish before giving up
+const lockWait = 30 * time.Second
+
+// Takes a lock on file so that only one cjdcmd edits it at a time, on
+// systems without flock. The lock is a ".lock" file that only one cjdcmd can
+// create, so one left behind by a crash has to be removed by hand.
+func lockConfig(file string) (unlock func(), err error) {
+	name := realPath(file) + ".lock"
+	deadline := time.Now().Add(lockWait)
+	waiting := false
+	for {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%v is still locked, remove %v if no other cjdcmd is running", file, name)
+		}
+		if !waiting {
+			fmt.Printf("Waiting for another cjdcmd to finish editing %v...\n", file)
+			waiting = true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
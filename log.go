@@ -0,0 +1,372 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultLogFormat = "full"
+
+	logKeepAlive  = 10 * time.Second
+	logMinBackoff = time.Second
+	logMaxBackoff = time.Minute
+)
+
+// Built in templates for -log-format
+var logFormats = map[string]string{
+	"short":  `{{.Time.Format "15:04:05"}} {{colour .Level}} {{highlight .Message}}`,
+	"full":   `{{.Counter}} {{.Time.Format "2006-01-02 15:04:05"}} {{colour .Level}} {{.File}}:{{.Line}} {{highlight .Message}}`,
+	"json":   `{{json .}}`,
+	"logfmt": `time={{.Time.Format "2006-01-02T15:04:05Z07:00"}} level={{.Level}} file={{.File}} line={{.Line}} msg={{logfmt .Message}}`,
+}
+
+// ANSI colours for each cjdns log level
+var levelColours = map[string]string{
+	"KEYS":     "35",
+	"DEBUG":    "90",
+	"INFO":     "32",
+	"WARN":     "33",
+	"ERROR":    "31",
+	"CRITICAL": "1;31",
+}
+
+// A log message as seen by -log-format templates
+type logLine struct {
+	Counter int       `json:"counter"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	File    string    `json:"file"`
+	Line    int       `json:"line"`
+	Message string    `json:"message"`
+}
+
+// Formats log messages with the template chosen by -log-format
+type logFormatter struct {
+	tmpl    *template.Template
+	counter int
+}
+
+// Creates a formatter from the name of a built in format or a template.
+// Levels and -highlight matches are only coloured if colour is true.
+func newLogFormatter(format string, colour bool) (*logFormatter, error) {
+	highlight, err := newHighlighter(colour)
+	if err != nil {
+		return nil, err
+	}
+	if preset, ok := logFormats[format]; ok {
+		format = preset
+	}
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+
+	funcs := template.FuncMap{
+		"colour": func(level string) string {
+			code, ok := levelColours[level]
+			if !colour || !ok {
+				return level
+			}
+			return "\x1b[" + code + "m" + level + "\x1b[0m"
+		},
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"logfmt":    logfmtValue,
+		"highlight": highlight,
+	}
+	tmpl, err := template.New("log").Funcs(funcs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log format: %v", err)
+	}
+	return &logFormatter{tmpl: tmpl}, nil
+}
+
+// Writes a single message, numbering it after the ones before it
+func (f *logFormatter) write(w io.Writer, msg *admin.LogMessage) error {
+	f.counter++
+	return f.tmpl.Execute(w, &logLine{
+		Counter: f.counter,
+		Time:    time.Unix(int64(msg.Time), 0),
+		Level:   msg.Level,
+		File:    msg.File,
+		Line:    msg.Line,
+		Message: msg.Message,
+	})
+}
+
+// Quotes a logfmt value if it needs it
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Returns true if log levels should be coloured
+func useColour() bool {
+	return isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// Connects to cjdns and subscribes to the log, asking cjdns to do whatever
+// filtering it can
+func subscribeLog(globalData *Data) (<-chan *admin.LogMessage, error) {
+	user, err := adminConnect()
+	if err != nil {
+		return nil, err
+	}
+	response := make(chan *admin.LogMessage)
+	file, line := logSubscription()
+	id, err := user.AdminLog_subscribe(LogLevel, file, line, response)
+	if err != nil {
+		if user.Conn != nil {
+			user.Conn.Close()
+		}
+		return nil, err
+	}
+	globalData.User = user
+	globalData.LoggingStreamID = id
+	return response, nil
+}
+
+// Pings cjdns every 10 seconds to keep the connection alive, sending the
+// first error on lost
+func keepAlive(user *admin.Conn, lost chan<- error, done <-chan bool) {
+	ticker := time.NewTicker(logKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := user.Ping(); err != nil {
+				lost <- err
+				return
+			}
+		}
+	}
+}
+
+// Everything done with each log message: recording it, then filtering it
+// and either printing it, forwarding it to syslog or counting it for a
+// summary
+type logPipeline struct {
+	recorder  *logRecorder
+	filter    *logFilter
+	formatter *logFormatter
+	syslog    *syslogForwarder
+	summary   *logSummary
+}
+
+// Passes a single message through the pipeline
+func (p *logPipeline) handle(msg *admin.LogMessage) error {
+	if p.recorder != nil {
+		if err := p.recorder.write(msg); err != nil {
+			return fmt.Errorf("Error recording log message: %v", err)
+		}
+	}
+	if !p.filter.match(msg) {
+		return nil
+	}
+	if p.summary != nil {
+		p.summary.add(msg)
+	}
+	if p.syslog != nil {
+		p.syslog.write(msg)
+		return nil
+	}
+	if p.summary != nil {
+		return nil
+	}
+	if err := p.formatter.write(os.Stdout, msg); err != nil {
+		return fmt.Errorf("Error formatting log message: %v", err)
+	}
+	return nil
+}
+
+// Finishes with the pipeline, closing the recording and the connection to
+// syslog and printing the summary if there is one
+func (p *logPipeline) close() {
+	if p.recorder != nil {
+		if err := p.recorder.close(); err != nil {
+			fmt.Println("Error closing the log recording:", err)
+		}
+	}
+	if p.syslog != nil {
+		p.syslog.close()
+	}
+	if p.summary != nil {
+		p.summary.print(os.Stdout, LogSummaryTop)
+	}
+}
+
+// Prints messages from the log stream until the connection to cjdns is lost,
+// or returns nil once stop is closed
+func readLog(globalData *Data, response <-chan *admin.LogMessage, p *logPipeline, stop <-chan struct{}) error {
+	lost := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go keepAlive(globalData.User, lost, done)
+
+	for {
+		select {
+		case input, ok := <-response:
+			if !ok {
+				return fmt.Errorf("the log stream was closed")
+			}
+			if err := p.handle(input); err != nil {
+				fmt.Println(err)
+				p.close()
+				os.Exit(1)
+			}
+		case err := <-lost:
+			return err
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// Marks the time the log stream was missing in the output
+func writeGap(from, to time.Time) {
+	if LogFormat == "json" {
+		out, _ := json.Marshal(map[string]interface{}{
+			"gap": map[string]interface{}{"from": from, "to": to},
+		})
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Printf("--- no log from %v to %v (%v), cjdns was unreachable ---\n",
+		from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05"), to.Sub(from).Truncate(time.Second))
+}
+
+// Subscribes to the cjdns log and prints every message until interrupted,
+// recording them all if -record was given and forwarding them to syslog
+// instead of printing them if -syslog was given. With -summary, messages are
+// counted for that long and then summarised, while still being forwarded to
+// syslog. With -follow, which -syslog implies, lost connections are retried
+// with backoff and the gap is marked in the output. An interrupt stops it,
+// closing the pipeline as usual, and a second one exits straight away.
+func streamLog(globalData *Data, interrupt <-chan os.Signal) {
+	formatter, err := newLogFormatter(LogFormat, useColour())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	filter, err := newLogFilter(false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p := &logPipeline{filter: filter, formatter: formatter}
+	if LogRecordDir != "" {
+		if p.recorder, err = newLogRecorder(LogRecordDir, int64(LogRecordSize)<<20, LogRecordAge); err != nil {
+			fmt.Println("Unable to record log:", err)
+			return
+		}
+	}
+	if LogSyslog != "" {
+		if p.syslog, err = newSyslogForwarder(LogSyslog); err != nil {
+			fmt.Println(err)
+			return
+		}
+		// A forwarder should outlive cjdns restarts
+		LogFollow = true
+	}
+
+	defer p.close()
+
+	var until <-chan time.Time
+	if LogSummary > 0 {
+		p.summary = newLogSummary()
+		until = time.After(LogSummary)
+		fmt.Fprintf(os.Stderr, "Collecting log messages for %v\n", LogSummary)
+	}
+
+	// Closed when the summary window is over or on ctrl+c
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-until:
+		case <-interrupt:
+			fmt.Println()
+		}
+		close(done)
+		<-interrupt
+		os.Exit(1)
+	}()
+
+	var lostAt time.Time
+	backoff := logMinBackoff
+	for {
+		response, err := subscribeLog(globalData)
+		if err != nil {
+			if !LogFollow {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			if lostAt.IsZero() {
+				lostAt = time.Now()
+			}
+			fmt.Fprintf(os.Stderr, "Unable to subscribe to the cjdns log, retrying in %v\n", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-done:
+				return
+			}
+			if backoff *= 2; backoff > logMaxBackoff {
+				backoff = logMaxBackoff
+			}
+			continue
+		}
+		if !lostAt.IsZero() {
+			fmt.Fprintln(os.Stderr, "Reconnected to cjdns")
+			if p.summary == nil {
+				writeGap(lostAt, time.Now())
+			}
+			lostAt = time.Time{}
+		}
+		backoff = logMinBackoff
+
+		err = readLog(globalData, response, p, done)
+		if err == nil {
+			// Interrupted, or the summary window is over
+			if err := globalData.User.AdminLog_unsubscribe(globalData.LoggingStreamID); err != nil {
+				fmt.Println(err)
+			}
+			globalData.User.Conn.Close()
+			return
+		}
+		if globalData.User.Conn != nil {
+			globalData.User.Conn.Close()
+		}
+		if !LogFollow {
+			fmt.Println("Error reading log response from cjdns:", err)
+			return
+		}
+		lostAt = time.Now()
+		fmt.Fprintln(os.Stderr, "Lost connection to cjdns:", err)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"github.com/inhies/go-cjdns/admin"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogPipelineSummaryAndSyslog(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	p := &logPipeline{filter: &logFilter{Level: -1, Line: -1}, summary: newLogSummary()}
+	if p.syslog, err = newSyslogForwarder("udp://" + server.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	msg := &admin.LogMessage{File: "a.c", Level: "WARN", Line: 1, Message: "hello", Time: 1}
+	if err := p.handle(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.summary.Total != 1 {
+		t.Errorf("summary counted %d messages, want 1", p.summary.Total)
+	}
+	buf := make([]byte, 2048)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("nothing was forwarded to syslog: %v", err)
+	}
+	if !strings.Contains(string(buf[:n]), "hello") {
+		t.Errorf("syslog got %q", buf[:n])
+	}
+}
+
+func TestLogFilterLineWithoutFile(t *testing.T) {
+	oldFiles, oldLine := LogFiles, LogFileLine
+	LogFiles, LogFileLine = nil, 12
+	defer func() { LogFiles, LogFileLine = oldFiles, oldLine }()
+
+	for _, offline := range []bool{false, true} {
+		f, err := newLogFilter(offline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for line, want := range map[int]bool{12: true, 13: false} {
+			msg := &admin.LogMessage{File: "a.c", Level: "WARN", Line: line, Message: "hello"}
+			if got := f.match(msg); got != want {
+				t.Errorf("offline %v: line %d matched %v, want %v", offline, line, got, want)
+			}
+		}
+	}
+}
+
+func TestLogSummaryPrint(t *testing.T) {
+	s := newLogSummary()
+	for _, level := range []string{"ZULU", "WARN", "ALPHA", "MIKE"} {
+		s.add(&admin.LogMessage{File: "a.c", Level: level, Line: 1, Message: "hello"})
+	}
+
+	var buf bytes.Buffer
+	s.print(&buf, 0)
+	out := buf.String()
+	for _, heading := range []string{"\nSources:\n", "\nMessages:\n"} {
+		if !strings.Contains(out, heading) {
+			t.Errorf("summary with no limit has no %q heading:\n%v", heading, out)
+		}
+	}
+	if strings.Contains(out, "Top 0") {
+		t.Errorf("summary with no limit says Top 0:\n%v", out)
+	}
+	if a, m, z := strings.Index(out, "ALPHA"), strings.Index(out, "MIKE"), strings.Index(out, "ZULU"); !(strings.Index(out, "WARN") < a && a < m && m < z) {
+		t.Errorf("unknown levels are not sorted after the known ones:\n%v", out)
+	}
+
+	buf.Reset()
+	s.print(&buf, 5)
+	if !strings.Contains(buf.String(), "\nTop 5 sources:\n") {
+		t.Errorf("summary limited to 5 has no Top 5 heading:\n%v", buf.String())
+	}
+}
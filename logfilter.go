@@ -0,0 +1,175 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The cjdns log levels, least important first
+var logLevels = []string{"KEYS", "DEBUG", "INFO", "WARN", "ERROR", "CRITICAL"}
+
+// Colours used for each -highlight rule in turn
+var highlightColours = []string{"1;33", "1;36", "1;35", "1;32", "1;34", "1;31"}
+
+// Filters applied to the log stream after cjdns has sent it. cjdns itself
+// can only filter on level and a single file and line.
+type logFilter struct {
+	Level   int
+	Files   map[string]bool
+	Line    int
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+	IPs     []string
+}
+
+// Builds the filter from the -logfile, -line, -include, -exclude and -ip
+// flags. Hostnames given to -ip are resolved first. If offline is true the
+// messages did not come from cjdns just now, so the level, file and line are
+// filtered here too.
+func newLogFilter(offline bool) (f *logFilter, err error) {
+	// The line is always checked here too, as cjdns only filters on it along
+	// with a single file
+	f = &logFilter{Level: -1, Line: LogFileLine}
+	if offline {
+		if f.Level = logLevelRank(LogLevel); f.Level < 0 {
+			return nil, fmt.Errorf("Unknown log level '%v'", LogLevel)
+		}
+	}
+	if len(LogFiles) > 1 || (offline && len(LogFiles) == 1) {
+		// cjdns can only filter on one file, so the rest is done here
+		f.Files = make(map[string]bool)
+		for _, file := range LogFiles {
+			f.Files[file] = true
+		}
+	}
+	if f.Include, err = compileAll(LogInclude); err != nil {
+		return nil, err
+	}
+	if f.Exclude, err = compileAll(LogExclude); err != nil {
+		return nil, err
+	}
+	for _, s := range LogIPs {
+		addrs := []string{s}
+		if net.ParseIP(s) == nil && validHost(s) {
+			if addrs, err = resolveHost(s); err != nil {
+				return nil, fmt.Errorf("Unable to resolve %v: %v", s, err)
+			}
+		}
+		for _, addr := range addrs {
+			ip := net.ParseIP(addr)
+			if ip == nil || ip.To4() != nil {
+				return nil, fmt.Errorf("Invalid IPv6 address '%v'", addr)
+			}
+			// cjdns logs addresses both in full and compressed
+			f.IPs = append(f.IPs, padIPv6(ip), ip.String())
+		}
+	}
+	return
+}
+
+// Returns how important a log level is, or -1 if it is not one cjdns uses
+func logLevelRank(level string) int {
+	for i, l := range logLevels {
+		if strings.EqualFold(l, level) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Compiles a list of regular expressions
+func compileAll(patterns []string) (res []*regexp.Regexp, err error) {
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression '%v': %v", p, err)
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+// Returns the file and line to ask cjdns to filter on
+func logSubscription() (file string, line int) {
+	if len(LogFiles) == 1 {
+		return LogFiles[0], LogFileLine
+	}
+	return "", -1
+}
+
+// Returns true if the message passes every filter
+func (f *logFilter) match(msg *admin.LogMessage) bool {
+	if f.Level >= 0 && logLevelRank(msg.Level) < f.Level {
+		return false
+	}
+	if f.Files != nil && !f.Files[msg.File] && !f.Files[filepath.Base(msg.File)] {
+		return false
+	}
+	if f.Line >= 0 && msg.Line != f.Line {
+		return false
+	}
+	if len(f.Include) > 0 && !anyMatch(f.Include, msg.Message) {
+		return false
+	}
+	if anyMatch(f.Exclude, msg.Message) {
+		return false
+	}
+	if len(f.IPs) > 0 {
+		lower := strings.ToLower(msg.Message)
+		for _, ip := range f.IPs {
+			if strings.Contains(lower, ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Returns true if any of the regular expressions match s
+func anyMatch(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a function that colours everything matching the -highlight rules,
+// or leaves the text alone if colour is false
+func newHighlighter(colour bool) (func(string) string, error) {
+	rules, err := compileAll(LogHighlight)
+	if err != nil {
+		return nil, err
+	}
+	return func(s string) string {
+		if !colour {
+			return s
+		}
+		for i, re := range rules {
+			code := highlightColours[i%len(highlightColours)]
+			s = re.ReplaceAllStringFunc(s, func(m string) string {
+				return "\x1b[" + code + "m" + m + "\x1b[0m"
+			})
+		}
+		return s
+	}, nil
+}
@@ -0,0 +1,264 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRecordSize = 10 // megabytes
+	defaultRecordAge  = time.Hour
+
+	recordPrefix = "cjdns-log-"
+	recordExt    = ".ndjson"
+)
+
+// Writes log messages as newline delimited JSON, starting a new file once
+// the current one is too big or too old and compressing the old one
+type logRecorder struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// Creates a recorder writing in to dir, which is created if needed. Segments
+// left uncompressed by an earlier run are compressed first.
+func newLogRecorder(dir string, maxSize int64, maxAge time.Duration) (*logRecorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	segments, err := recordedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range segments {
+		if strings.HasSuffix(name, recordExt) {
+			if err := gzipFile(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &logRecorder{dir: dir, maxSize: maxSize, maxAge: maxAge}, nil
+}
+
+// Records a single message
+func (r *logRecorder) write(msg *admin.LogMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if r.file != nil && (r.size+int64(len(line)) > r.maxSize || time.Since(r.started) > r.maxAge) {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+// Starts a new segment named after the current time, so that sorting the
+// names puts them in order
+func (r *logRecorder) open() (err error) {
+	r.started = time.Now()
+	for stamp := r.started; ; stamp = stamp.Add(time.Microsecond) {
+		name := filepath.Join(r.dir, recordPrefix+stamp.Format("20060102-150405.000000")+recordExt)
+		if fileExists(name + ".gz") {
+			continue
+		}
+		r.file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		r.size = 0
+		return
+	}
+}
+
+// Closes the current segment and compresses it
+func (r *logRecorder) rotate() error {
+	name := r.file.Name()
+	if err := r.close(); err != nil {
+		return err
+	}
+	return gzipFile(name)
+}
+
+// Closes the current segment, leaving it uncompressed
+func (r *logRecorder) close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Replaces a file with a gzipped copy of it
+func gzipFile(name string) (err error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Returns the recorded segments in a directory, oldest first
+func recordedFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, recordPrefix) &&
+			(strings.HasSuffix(name, recordExt) || strings.HasSuffix(name, recordExt+".gz")) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	// The names start with the time they were started
+	sort.Strings(files)
+	return files, nil
+}
+
+// Reads recorded messages from r, which may be gzipped, passing each to handle
+func readRecording(r io.Reader, handle func(*admin.LogMessage) error) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	dec := json.NewDecoder(br)
+	for {
+		msg := new(admin.LogMessage)
+		if err := dec.Decode(msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// Replays a single recorded file, or stdin if file is -, closing it before
+// the next one is opened
+func replayFile(file string, handle func(*admin.LogMessage) error) error {
+	if file == "-" {
+		return readRecording(os.Stdin, handle)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readRecording(f, handle)
+}
+
+// Prints recorded log files through the same filters and formatting as the
+// log command, or summarises them if -summary was given. Directories are
+// replayed one segment at a time, oldest first. Returns false if they
+// couldn't all be read.
+func logReplay(data []string) bool {
+	if len(data) == 0 {
+		fmt.Println("You must specify the recorded log files or directory to replay, or - for stdin")
+		return false
+	}
+	formatter, err := newLogFormatter(LogFormat, useColour())
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	filter, err := newLogFilter(true)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	p := &logPipeline{filter: filter, formatter: formatter}
+	if LogSummary > 0 {
+		p.summary = newLogSummary()
+	}
+
+	var files []string
+	for _, arg := range data {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			segments, err := recordedFiles(arg)
+			if err != nil {
+				fmt.Println(err)
+				return false
+			}
+			files = append(files, segments...)
+		} else {
+			files = append(files, arg)
+		}
+	}
+
+	for _, file := range files {
+		if err := replayFile(file, p.handle); err != nil {
+			fmt.Printf("Error reading %v: %v\n", file, err)
+			// Still show the summary of what was replayed before
+			p.close()
+			return false
+		}
+	}
+	p.close()
+	return true
+}
@@ -0,0 +1,143 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const defaultSummaryTop = 10
+
+// Parts of messages that change between otherwise identical messages, in the
+// order they are replaced
+var logNormalisers = []struct {
+	re   *regexp.Regexp
+	with string
+}{
+	{regexp.MustCompile(`[0-9a-z]{52}\.k`), "<key>"},
+	{regexp.MustCompile(`(?i)\bfc[0-9a-f]{2}(:[0-9a-f]{0,4}){2,7}`), "<ip>"},
+	{regexp.MustCompile(`(?i)\b([0-9a-f]{4}\.){3}[0-9a-f]{4}\b`), "<path>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<addr>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b`), "<hex>"},
+	{regexp.MustCompile(`\d+`), "<n>"},
+}
+
+// Counts log messages by level, by where they were logged and by what they
+// say
+type logSummary struct {
+	Total    int
+	Levels   map[string]int
+	Sources  map[string]int
+	Messages map[string]int
+}
+
+func newLogSummary() *logSummary {
+	return &logSummary{
+		Levels:   make(map[string]int),
+		Sources:  make(map[string]int),
+		Messages: make(map[string]int),
+	}
+}
+
+// Counts a single message
+func (s *logSummary) add(msg *admin.LogMessage) {
+	s.Total++
+	s.Levels[msg.Level]++
+	s.Sources[msg.File+":"+strconv.Itoa(msg.Line)]++
+	s.Messages[normaliseMessage(msg.Message)]++
+}
+
+// Replaces addresses, keys, paths and numbers in a message with placeholders
+// so that messages which only differ in those are counted together
+func normaliseMessage(msg string) string {
+	for _, n := range logNormalisers {
+		msg = n.re.ReplaceAllString(msg, n.with)
+	}
+	return msg
+}
+
+// A count of one thing in the summary
+type summaryCount struct {
+	Name  string
+	Count int
+}
+
+// Returns the counts largest first, and alphabetically when they are equal,
+// keeping at most top of them if top is above zero
+func topCounts(counts map[string]int, top int) []summaryCount {
+	out := make([]summaryCount, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, summaryCount{name, n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if top > 0 && len(out) > top {
+		out = out[:top]
+	}
+	return out
+}
+
+// Prints the summary, showing only the top most common sources and messages,
+// or all of them if top isn't above zero
+func (s *logSummary) print(w io.Writer, top int) {
+	fmt.Fprintf(w, "%d messages\n", s.Total)
+	if s.Total == 0 {
+		return
+	}
+	percent := func(n int) float64 {
+		return float64(n) * 100 / float64(s.Total)
+	}
+
+	fmt.Fprintln(w, "\nBy level:")
+	for _, level := range logLevels {
+		if n := s.Levels[level]; n > 0 {
+			fmt.Fprintf(w, "\t%8d %5.1f%%  %v\n", n, percent(n), level)
+		}
+	}
+	// Levels cjdns doesn't use come last, in order of name
+	var unknown []string
+	for level := range s.Levels {
+		if logLevelRank(level) < 0 {
+			unknown = append(unknown, level)
+		}
+	}
+	sort.Strings(unknown)
+	for _, level := range unknown {
+		n := s.Levels[level]
+		fmt.Fprintf(w, "\t%8d %5.1f%%  %v\n", n, percent(n), level)
+	}
+
+	sources, messages := "Sources", "Messages"
+	if top > 0 {
+		sources, messages = fmt.Sprintf("Top %d sources", top), fmt.Sprintf("Top %d messages", top)
+	}
+	fmt.Fprintf(w, "\n%v:\n", sources)
+	for _, c := range topCounts(s.Sources, top) {
+		fmt.Fprintf(w, "\t%8d %5.1f%%  %v\n", c.Count, percent(c.Count), c.Name)
+	}
+
+	fmt.Fprintf(w, "\n%v:\n", messages)
+	for _, c := range topCounts(s.Messages, top) {
+		fmt.Fprintf(w, "\t%8d %5.1f%%  %v\n", c.Count, percent(c.Count), c.Name)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syslogFacility = 3 // daemon
+	syslogAppName  = "cjdns"
+
+	// The enterprise number set aside for documentation, as cjdns has none
+	syslogSDID = "cjdns@32473"
+)
+
+// Syslog severities for each cjdns log level
+var syslogSeverities = map[string]int{
+	"KEYS":     7, // debug
+	"DEBUG":    7, // debug
+	"INFO":     6, // informational
+	"WARN":     4, // warning
+	"ERROR":    3, // error
+	"CRITICAL": 2, // critical
+}
+
+// Sends log messages to a syslog server as RFC 5424 messages, reconnecting
+// whenever the connection is lost
+type syslogForwarder struct {
+	network, addr string
+	hostname      string
+
+	conn    net.Conn
+	framing string
+	retryAt time.Time
+	backoff time.Duration
+	dropped int
+}
+
+// Creates a forwarder for a udp://host:port, tcp://host:port or
+// unix:///path address. Nothing is sent until the first message.
+func newSyslogForwarder(addr string) (*syslogForwarder, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &syslogForwarder{network: u.Scheme, addr: u.Host, backoff: logMinBackoff}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			s.addr = net.JoinHostPort(u.Host, "514")
+		}
+	case "unix", "unixgram":
+		s.addr = u.Path
+	default:
+		return nil, fmt.Errorf("Unknown syslog address '%v', use udp://host:514, tcp://host:514 or unix:///dev/log", addr)
+	}
+	if s.hostname, err = os.Hostname(); err != nil || s.hostname == "" {
+		s.hostname = "-"
+	}
+	return s, nil
+}
+
+// Connects to the syslog server. Local sockets are usually datagram sockets
+// but some systems use stream sockets instead. Messages sent over TCP are
+// prefixed with their length and those over a local stream end in a newline.
+func (s *syslogForwarder) dial() (err error) {
+	s.framing = s.network
+	if s.network == "unix" {
+		if s.conn, err = net.Dial("unixgram", s.addr); err == nil {
+			s.framing = "unixgram"
+			return
+		}
+	}
+	s.conn, err = net.DialTimeout(s.network, s.addr, 10*time.Second)
+	return
+}
+
+// Sends a single message, dropping it if the server can't be reached. A
+// failed send is retried once on a fresh connection.
+func (s *syslogForwarder) write(msg *admin.LogMessage) {
+	line := syslogMessage(msg, s.hostname)
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if time.Now().Before(s.retryAt) {
+				break
+			}
+			if err := s.dial(); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to reach syslog at %v: %v, retrying in %v\n", s.addr, err, s.backoff)
+				s.retryAt = time.Now().Add(s.backoff)
+				if s.backoff *= 2; s.backoff > logMaxBackoff {
+					s.backoff = logMaxBackoff
+				}
+				break
+			}
+			if s.dropped > 0 {
+				fmt.Fprintf(os.Stderr, "Reconnected to syslog at %v, %d messages were dropped\n", s.addr, s.dropped)
+				s.dropped = 0
+			}
+			s.backoff = logMinBackoff
+		}
+
+		out := line
+		switch s.framing {
+		case "tcp":
+			out = strconv.Itoa(len(line)) + " " + line
+		case "unix":
+			out = line + "\n"
+		}
+		if _, err := s.conn.Write([]byte(out)); err == nil {
+			return
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.dropped++
+}
+
+// Closes the connection to the syslog server, reporting any messages that
+// were never sent
+func (s *syslogForwarder) close() {
+	if s.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "%d messages could not be sent to syslog at %v\n", s.dropped, s.addr)
+	}
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Formats a message as RFC 5424 with the file, line and level as structured
+// data
+func syslogMessage(msg *admin.LogMessage, hostname string) string {
+	severity, ok := syslogSeverities[strings.ToUpper(msg.Level)]
+	if !ok {
+		severity = 5 // notice
+	}
+	msgID := msg.Level
+	if msgID == "" {
+		msgID = "-"
+	}
+	return fmt.Sprintf("<%d>1 %v %v %v - %v [%v file=\"%v\" line=\"%d\" level=\"%v\"] %v",
+		syslogFacility*8+severity,
+		time.Unix(int64(msg.Time), 0).UTC().Format(time.RFC3339),
+		hostname, syslogAppName, msgID, syslogSDID,
+		sdEscape(msg.File), msg.Line, sdEscape(msg.Level), msg.Message)
+}
+
+// Escapes a structured data parameter value
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
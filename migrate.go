@@ -0,0 +1,298 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+)
+
+// The config layouts cjdns has used over time:
+//
+//	0: one settings object per interface type
+//	1: an array of settings blocks per interface type, with tunDevice and
+//	   ipTunnel at the top level
+//	2: tunDevice moved to router.interface and ipTunnel to router.ipTunnel
+//	3: security as a list of objects and no authType on authorized passwords
+const latestConfigVersion = 3
+
+// A single step that moves a config from one version to the next, or back.
+// Each returns a description of every change it made.
+type migration struct {
+	Up   func(conf map[string]interface{}) ([]string, error)
+	Down func(conf map[string]interface{}) ([]string, error)
+}
+
+// Indexed by the version being migrated from when going up
+var migrations = []migration{
+	{interfacesToArrays, interfacesToObjects},
+	{moveToRouter, moveFromRouter},
+	{securityToObjects, securityToStrings},
+}
+
+// Keys older configs kept at the top level and the router section they moved
+// to, along with their new name there
+var routerKeys = []struct {
+	Old, Section, New string
+}{
+	{"tunDevice", "interface", "tunDevice"},
+	{"ipTunnel", "", "ipTunnel"},
+}
+
+// Works out which layout a config uses from its structure. The oldest layout
+// anything in it belongs to is returned, so configs that mix layouts are
+// migrated all the way. A config with nothing to tell two layouts apart is
+// taken to be the newer one.
+func configVersion(conf map[string]interface{}) int {
+	is, _ := conf["interfaces"].(map[string]interface{})
+	for _, v := range is {
+		if _, ok := v.(map[string]interface{}); ok {
+			return 0
+		}
+	}
+
+	for _, k := range routerKeys {
+		if _, ok := conf[k.Old]; ok {
+			return 1
+		}
+	}
+
+	security, _ := conf["security"].([]interface{})
+	for _, s := range security {
+		if _, ok := s.(string); ok {
+			return 2
+		}
+	}
+	passwords, _ := conf["authorizedPasswords"].([]interface{})
+	for _, p := range passwords {
+		if pass, ok := p.(map[string]interface{}); ok {
+			if _, ok := pass["authType"]; ok {
+				return 2
+			}
+		}
+	}
+	return latestConfigVersion
+}
+
+// Rewrites conf from version from to version to, returning every change made
+func migrateConfig(conf map[string]interface{}, from, to int) (changes []string, err error) {
+	for from != to {
+		var step []string
+		if from < to {
+			step, err = migrations[from].Up(conf)
+			from++
+		} else {
+			from--
+			step, err = migrations[from].Down(conf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range step {
+			changes = append(changes, fmt.Sprintf("to version %d: %v", from, c))
+		}
+	}
+	return
+}
+
+// 0 -> 1: wrap each interface's settings object in an array
+func interfacesToArrays(conf map[string]interface{}) (changes []string, err error) {
+	is, _ := conf["interfaces"].(map[string]interface{})
+	for _, name := range sortedKeys(is) {
+		if block, ok := is[name].(map[string]interface{}); ok {
+			is[name] = []interface{}{block}
+			changes = append(changes, fmt.Sprintf("interfaces.%v: moved the settings in to an array", name))
+		}
+	}
+	return
+}
+
+// 1 -> 0: unwrap each interface's array, which must only have one block
+func interfacesToObjects(conf map[string]interface{}) (changes []string, err error) {
+	is, _ := conf["interfaces"].(map[string]interface{})
+	for _, name := range sortedKeys(is) {
+		blocks, ok := is[name].([]interface{})
+		if !ok {
+			continue
+		}
+		if len(blocks) != 1 {
+			return nil, fmt.Errorf("interfaces.%v has %d settings blocks but version 0 only allows one", name, len(blocks))
+		}
+		is[name] = blocks[0]
+		changes = append(changes, fmt.Sprintf("interfaces.%v: moved the settings out of the array", name))
+	}
+	return
+}
+
+// Returns the object at key in m, creating it if create is true. Returns an
+// error if something other than an object is there.
+func subsection(m map[string]interface{}, key, path string, create bool) (map[string]interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+	v, ok := m[key]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		sub := make(map[string]interface{})
+		m[key] = sub
+		return sub, nil
+	}
+	sub, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v is not an object", path)
+	}
+	return sub, nil
+}
+
+// Returns the router section a key moves to, and its path
+func routerSection(conf map[string]interface{}, section string, create bool) (m map[string]interface{}, path string, err error) {
+	path = "router"
+	if m, err = subsection(conf, "router", path, create); err != nil || section == "" {
+		return
+	}
+	path += "." + section
+	m, err = subsection(m, section, path, create)
+	return
+}
+
+// 1 -> 2: move tunDevice and ipTunnel in to the router section
+func moveToRouter(conf map[string]interface{}) (changes []string, err error) {
+	for _, k := range routerKeys {
+		v, ok := conf[k.Old]
+		if !ok {
+			continue
+		}
+		section, path, err := routerSection(conf, k.Section, true)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := section[k.New]; ok {
+			return nil, fmt.Errorf("both %v and %v.%v are set, remove one of them first", k.Old, path, k.New)
+		}
+		section[k.New] = v
+		delete(conf, k.Old)
+		changes = append(changes, fmt.Sprintf("%v: moved to %v.%v", k.Old, path, k.New))
+	}
+	return
+}
+
+// 2 -> 1: move tunDevice and ipTunnel out of the router section
+func moveFromRouter(conf map[string]interface{}) (changes []string, err error) {
+	for _, k := range routerKeys {
+		section, path, err := routerSection(conf, k.Section, false)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := section[k.New]
+		if !ok {
+			continue
+		}
+		if _, ok := conf[k.Old]; ok {
+			return nil, fmt.Errorf("both %v and %v.%v are set, remove one of them first", k.Old, path, k.New)
+		}
+		conf[k.Old] = v
+		delete(section, k.New)
+		changes = append(changes, fmt.Sprintf("%v.%v: moved to %v", path, k.New, k.Old))
+	}
+	return
+}
+
+// 2 -> 3: turn security strings in to objects and drop authType, which cjdns
+// no longer reads
+func securityToObjects(conf map[string]interface{}) (changes []string, err error) {
+	if security, ok := conf["security"].([]interface{}); ok {
+		for i, s := range security {
+			if name, ok := s.(string); ok {
+				security[i] = map[string]interface{}{name: 1}
+				changes = append(changes, fmt.Sprintf("security[%d]: changed \"%v\" to {\"%v\": 1}", i, name, name))
+			}
+		}
+	}
+
+	passwords, _ := conf["authorizedPasswords"].([]interface{})
+	for i, p := range passwords {
+		if pass, ok := p.(map[string]interface{}); ok {
+			if _, ok := pass["authType"]; ok {
+				delete(pass, "authType")
+				changes = append(changes, fmt.Sprintf("authorizedPasswords[%d]: removed authType", i))
+			}
+		}
+	}
+	return
+}
+
+// 3 -> 2: turn enabled security objects back in to strings
+func securityToStrings(conf map[string]interface{}) (changes []string, err error) {
+	if security, ok := conf["security"].([]interface{}); ok {
+		for i, s := range security {
+			obj, ok := s.(map[string]interface{})
+			if !ok || len(obj) != 1 {
+				continue
+			}
+			for name, v := range obj {
+				if n, ok := v.(float64); ok && n == 1 && name != "setuser" {
+					security[i] = name
+					changes = append(changes, fmt.Sprintf("security[%d]: changed {\"%v\": 1} to \"%v\"", i, name, name))
+				}
+			}
+		}
+	}
+	return
+}
+
+// Rewrites the config file in the layout given by -to, listing every change
+func migrateConfigFile() bool {
+	if MigrateTo < 0 || MigrateTo > latestConfigVersion {
+		fmt.Printf("Unknown config layout %d, use 0 to %d\n", MigrateTo, latestConfigVersion)
+		return false
+	}
+
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	unlock, err := lockConfigEdit()
+	if err != nil {
+		fmt.Println("Unable to lock config:", err)
+		return false
+	}
+	defer unlock()
+
+	fmt.Printf("Loading configuration from: %v... ", File)
+	conf, err := loadExtConfig(File)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return false
+	}
+	fmt.Printf("Loaded\n")
+
+	from := configVersion(conf)
+	fmt.Printf("Your config uses layout %d\n", from)
+	if from == MigrateTo {
+		fmt.Println("Nothing to do")
+		return true
+	}
+
+	changes, err := migrateConfig(conf, from, MigrateTo)
+	if err != nil {
+		fmt.Println("Unable to migrate config:", err)
+		return false
+	}
+	fmt.Printf("Migrating to layout %d:\n", MigrateTo)
+	for _, c := range changes {
+		fmt.Println("\t" + c)
+	}
+	return writeConfig(conf)
+}
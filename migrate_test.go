@@ -0,0 +1,113 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Parses a config written as JSON in a test
+func testConfig(t *testing.T, s string) map[string]interface{} {
+	var conf map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &conf); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// Layouts of the same config, indexed by version
+var testLayouts = []string{
+	`{"interfaces": {"UDPInterface": {"bind": "0.0.0.0:1"}},
+	  "tunDevice": "tun0", "ipTunnel": {"allowedConnections": []},
+	  "router": {"interface": {"type": "TUNInterface"}},
+	  "security": ["nofiles", {"setuser": "nobody"}],
+	  "authorizedPasswords": [{"password": "x"}]}`,
+	`{"interfaces": {"UDPInterface": [{"bind": "0.0.0.0:1"}]},
+	  "tunDevice": "tun0", "ipTunnel": {"allowedConnections": []},
+	  "router": {"interface": {"type": "TUNInterface"}},
+	  "security": ["nofiles", {"setuser": "nobody"}],
+	  "authorizedPasswords": [{"password": "x"}]}`,
+	`{"interfaces": {"UDPInterface": [{"bind": "0.0.0.0:1"}]},
+	  "router": {"interface": {"type": "TUNInterface", "tunDevice": "tun0"}, "ipTunnel": {"allowedConnections": []}},
+	  "security": ["nofiles", {"setuser": "nobody"}],
+	  "authorizedPasswords": [{"password": "x"}]}`,
+	`{"interfaces": {"UDPInterface": [{"bind": "0.0.0.0:1"}]},
+	  "router": {"interface": {"type": "TUNInterface", "tunDevice": "tun0"}, "ipTunnel": {"allowedConnections": []}},
+	  "security": [{"nofiles": 1}, {"setuser": "nobody"}],
+	  "authorizedPasswords": [{"password": "x"}]}`,
+}
+
+func TestConfigVersion(t *testing.T) {
+	tests := []struct {
+		conf string
+		want int
+	}{
+		{testLayouts[0], 0},
+		{testLayouts[1], 1},
+		{testLayouts[2], 2},
+		{testLayouts[3], 3},
+		{`{"authorizedPasswords": [{"password": "x", "authType": 1}]}`, 2},
+		// The version key cjdns writes is not what the layout is judged by
+		{`{"interfaces": {"UDPInterface": {}}, "version": 2}`, 0},
+		{`{}`, latestConfigVersion},
+	}
+	for _, test := range tests {
+		if got := configVersion(testConfig(t, test.conf)); got != test.want {
+			t.Errorf("configVersion(%v) = %d, want %d", test.conf, got, test.want)
+		}
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	for from := range testLayouts {
+		for to := range testLayouts {
+			conf := testConfig(t, testLayouts[from])
+			changes, err := migrateConfig(conf, from, to)
+			if err != nil {
+				t.Errorf("%d to %d: %v", from, to, err)
+				continue
+			}
+			// Compare through JSON so numbers are the same type
+			b, _ := json.Marshal(conf)
+			if got, want := testConfig(t, string(b)), testConfig(t, testLayouts[to]); !reflect.DeepEqual(got, want) {
+				t.Errorf("%d to %d: got %s", from, to, b)
+			}
+			if from != to && len(changes) == 0 {
+				t.Errorf("%d to %d: no changes reported", from, to)
+			}
+			if got := configVersion(conf); got != to {
+				t.Errorf("%d to %d: the result looks like version %d", from, to, got)
+			}
+		}
+	}
+}
+
+func TestMigrateConfigErrors(t *testing.T) {
+	tests := []struct {
+		conf     string
+		from, to int
+	}{
+		{`{"interfaces": {"UDPInterface": [{}, {}]}}`, 1, 0},
+		{`{"ipTunnel": {}, "router": {"ipTunnel": {}}}`, 1, 2},
+		{`{"tunDevice": "tun0", "router": {"interface": "tun"}}`, 1, 2},
+		{`{"tunDevice": "tun0", "router": {"interface": {"tunDevice": "tun1"}}}`, 2, 1},
+	}
+	for _, test := range tests {
+		if _, err := migrateConfig(testConfig(t, test.conf), test.from, test.to); err == nil {
+			t.Errorf("migrating %v from %d to %d succeeded, want an error", test.conf, test.from, test.to)
+		}
+	}
+}
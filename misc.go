@@ -16,15 +16,15 @@ package main
 import (
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/inhies/go-cjdns/admin"
 	"github.com/inhies/go-cjdns/config"
-	"io/ioutil"
-	"math/rand"
 	"net"
 	"os"
-	"os/user"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -52,113 +52,43 @@ func gotYes(defaultYes bool) bool {
 	return false
 }
 
-// Reads the .cjdnsadmin file and returns the structured contents
-func readCjdnsadmin(file string) (admin *admin.CjdnsAdminConfig, err error) {
-	rawFile, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil, err
-	}
-
-	raw, err := stripComments(rawFile)
-	if err != nil {
-		return nil, err
-	}
-
-	err = json.Unmarshal(raw, &admin)
-	if err != nil {
-		return nil, err
-	}
-	if err != nil {
-		// BUG(inhies): Find a better way of dealing with these errors.
-		if e, ok := err.(*json.SyntaxError); ok {
-			// BUG(inhies): Instead of printing x amount of characters, print the previous and following 2 lines
-			fmt.Println("Invalid JSON") //" at byte", e.Offset, "(after stripping comments...)")
-			fmt.Println("----------------------------------------")
-			fmt.Println(string(raw[e.Offset-60 : e.Offset+60]))
-			fmt.Println("----------------------------------------")
-		} else if _, ok := err.(*json.InvalidUTF8Error); ok {
-			fmt.Println("Invalid UTF-8")
-		} else if e, ok := err.(*json.InvalidUnmarshalError); ok {
-			fmt.Println("Invalid unmarshall type", e.Type)
-			fmt.Println(err)
-		} else if e, ok := err.(*json.UnmarshalFieldError); ok {
-			fmt.Println("Invalid unmarshall field", e.Field, e.Key, e.Type)
-		} else if e, ok := err.(*json.UnmarshalTypeError); ok {
-			fmt.Println("Invalid JSON")
-			fmt.Println("Expected", e.Type, "but received a", e.Value)
-			fmt.Println("I apologize for not being more helpful")
-		} else if e, ok := err.(*json.UnsupportedTypeError); ok {
-			fmt.Println("Invalid JSON")
-			fmt.Println("I am unable to utilize type", e.Type)
-		} else if e, ok := err.(*json.UnsupportedValueError); ok {
-			fmt.Println("Invalid JSON")
-			fmt.Println("I am unable to utilize value", e.Value, e.Str)
-		}
+// Reads the .cjdnsadmin file and returns the node chosen with -node, or the
+// default one
+func readCjdnsadmin(file string) (*admin.CjdnsAdminConfig, error) {
+	f := new(cjdnsadminFile)
+	if err := readJSONFile(file, f); err != nil {
 		return nil, err
 	}
-	return
-
+	return f.node(file)
 }
 
 // Reads the configuration file specified in global variable File
-// and sets the admin credentials
 func readConfig() (conf *config.Config, err error) {
-	conf, err = config.LoadMinConfig(File)
-	if err != nil || len(conf.Admin.Password) == 0 {
-		return
-	}
-
-	AdminPassword = conf.Admin.Password
-	AdminBind = conf.Admin.Bind
-
+	conf = new(config.Config)
+	err = readJSONFile(File, conf)
 	return
 }
 
 // Attempt to connect to cjdns
 func adminConnect() (user *admin.Conn, err error) {
-	// If nothing else has already set this
-	var cjdnsAdmin *admin.CjdnsAdminConfig
-	if AdminBind == "" || AdminPassword == "" {
-		// If we still have no idea which configuration file to use
-		if File == "" {
-			if !userSpecifiedCjdnsadmin {
-				cjdnsAdmin, err = loadCjdnsadmin()
-				if err != nil {
-					fmt.Println("Unable to load configuration file:", err)
-					return
-				}
-			} else {
-				cjdnsAdmin, err = readCjdnsadmin(userCjdnsadmin)
-				if err != nil {
-					fmt.Println("Error loading cjdnsadmin file:", err)
-					return
-				}
-			}
-
-			// Set the admin credentials from the .cjdnsadmin file
-			//AdminPassword = cjdnsAdmin.Password
-			//AdminBind = cjdnsAdmin.Addr + ":" + strconv.Itoa(cjdnsAdmin.Port)
-
-			// If File and OutFile aren't already set, set them
-			// Note that they could still be empty if the "config"
-			// entry isnt in .cjdnsadmin
-			if File == "" {
-				File = cjdnsAdmin.Config
-			}
+	creds, err := resolveAdmin()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if creds.Password == "" {
+		err = fmt.Errorf("No admin password found")
+		fmt.Println("No admin password found, use `cjdcmd whereis` to see where cjdcmd looked")
+		return
+	}
 
-			if OutFile == "" {
-				OutFile = cjdnsAdmin.Config
-			}
-		} else {
-			// File is set so use it
-			_, err = readConfig()
-			if err != nil {
-				err = fmt.Errorf("Unable to load configuration file:", err.Error())
-				return nil, err
-			}
-		}
+	// If File isn't already set, set it. Note that it could still be empty
+	// if no config file was named anywhere
+	if File == "" {
+		File = creds.Config
 	}
-	user, err = admin.Connect(cjdnsAdmin)
+
+	user, err = admin.Connect(&creds.CjdnsAdminConfig)
 	if err != nil {
 		if e, ok := err.(net.Error); ok {
 			if e.Timeout() {
@@ -176,38 +106,117 @@ func adminConnect() (user *admin.Conn, err error) {
 	return
 }
 
-// Check if a file exists
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	if err == nil {
-		return true
+// Sets File to the configuration file named by the admin details unless
+// --file was given
+func setConfigFile() (err error) {
+	if File != "" {
+		return
 	}
-	return false
+	creds, err := resolveAdmin()
+	if err != nil {
+		return
+	}
+	File = creds.Config
+	if File == "" {
+		return fmt.Errorf("Please specify the configuration file in your .cjdnsadmin file or pass the --file flag.")
+	}
+	return
 }
 
-// Attempt to read the .cjdnsadmin file from the users home directory
-func loadCjdnsadmin() (cjdnsAdmin *admin.CjdnsAdminConfig, err error) {
-	sudo_user := os.Getenv("SUDO_USER")
-	var read_err error
-	var tUser *user.User
-	if sudo_user != "" {
-		tUser, read_err = user.Lookup(sudo_user)
-		if !fileExists(tUser.HomeDir + "/.cjdnsadmin") {
-			tUser, read_err = user.Current()
+// Returns the path of the .cjdnsadmin file in the user's home directory
+func homeCjdnsadmin() (string, error) {
+	home, err := userHome()
+	if err != nil {
+		return "", fmt.Errorf("I was unable to get your home directory, please manually specify where to save the file with --outfile")
+	}
+	return filepath.Join(home, ".cjdnsadmin"), nil
+}
+
+// Saves the admin details to a .cjdnsadmin file, which defaults to the one in
+// the user's home directory, prompting before overwriting it
+func saveCjdnsadmin(file string, cjdnsAdmin *admin.CjdnsAdminConfig) {
+	jsonout, err := json.MarshalIndent(cjdnsAdmin, "", "\t")
+	if err != nil {
+		fmt.Println("Unable to create JSON for .cjdnsadmin")
+		return
+	}
+
+	if file == "" {
+		if file, err = homeCjdnsadmin(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	// Check if the output file exists and prompt befoer overwriting
+	if _, err := os.Stat(file); err == nil {
+		fmt.Printf("Overwrite %v? [y/N]: ", file)
+		if !gotYes(false) {
+			return
 		}
 	} else {
-		tUser, read_err = user.Current()
+		fmt.Println("Saving to", file)
 	}
-	if read_err != nil {
-		return
+
+	if err := writeFileAtomic(file, jsonout, 0600); err != nil {
+		fmt.Println("Error saving .cjdnsadmin:", err)
 	}
-	cjdnsAdmin, err = readCjdnsadmin(tUser.HomeDir + "/.cjdnsadmin")
-	if err != nil {
-		return
+}
+
+// A flag that may be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Parses flags wherever they appear, so they can follow the command's own
+// arguments, and returns those arguments. "-", negative numbers and anything
+// after "--" are arguments rather than flags.
+func parseArgs(fs *flag.FlagSet, args []string) (rest []string) {
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			return append(rest, args[1:]...)
+		}
+		if _, err := strconv.ParseFloat(arg, 64); err == nil || arg == "-" || !strings.HasPrefix(arg, "-") {
+			rest = append(rest, arg)
+			args = args[1:]
+			continue
+		}
+		fs.Parse(args)
+		parsed := len(args) - len(fs.Args())
+		if args[parsed-1] == "--" {
+			return append(rest, fs.Args()...)
+		}
+		args = fs.Args()
 	}
 	return
 }
 
+// Returns true if f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	stats, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stats.Mode()&os.ModeCharDevice != 0
+}
+
+// Check if a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true
+	}
+	return false
+}
+
 // Fills out an IPv6 address to the full 32 bytes
 // This shouldn't be needed in newer versions of cjdns
 
@@ -361,63 +370,85 @@ func usage() {
 	fmt.Println("                                                  each known hop to the tar on all known paths")
 	fmt.Println("ip <cjdns public key>                        --  Converts a cjdns public key to its corresponding")
 	fmt.Println("                                                  IPv6 address")
+	fmt.Println("ip <- | key...> [-batch file] [-json]        --  Converts many public keys read from stdin, files or")
+	fmt.Println("                                                  arguments, printing tab separated columns or JSON")
+	fmt.Println("ip --private <hex private key>               --  Derives the public key and IPv6 address for a")
+	fmt.Println("                                                  private key")
+	fmt.Println("whoami [-file]                               --  Derives your public key and IPv6 address from the")
+	fmt.Println("                                                  private key in your config file and checks the config")
+	fmt.Println("                                                  matches")
+	fmt.Println("genkey [-vanity prefix]                      --  Generates a new private key, public key and IPv6")
+	fmt.Println("                                                  address, optionally one whose address starts with")
+	fmt.Println("                                                  prefix")
+	fmt.Println("genconf [-template] [-port] [-eth] [-peers]  --  Generates a new config for a server, client or eth-only")
+	fmt.Println("                                                  node, and a matching .cjdnsadmin with")
+	fmt.Println("                                                  -write-cjdnsadmin")
 	fmt.Println("peers [<IPv6/DNS/Path>]                      --  Displays a list of currently connected peers for a")
 	fmt.Println("                                                 node, is no node is specified your peers are shown.")
 	fmt.Println("host <IPv6/DNS>                              --  Returns a list of all known IP addresses for a")
 	fmt.Println("                                                  specified hostname or the hostname for an address")
+	fmt.Println("host <- | name...> [-batch file] [-json]     --  Resolves many addresses or hostnames at once")
 	fmt.Println("hostname [new hypedns hostname]              --  Without arguments, returns your HypeDNS hostname.")
 	fmt.Println("                                                  Passing a new hostname will change your HypeDNS")
 	fmt.Println("                                                  record")
+	fmt.Println("hosts [list]                                 --  Lists the names in cjdcmd's hosts file, which is checked")
+	fmt.Println("                                                  before DNS")
+	fmt.Println("hosts add <name> <ip|key>                    --  Gives a name to an address or public key")
+	fmt.Println("hosts remove <name|ip>                       --  Removes a name, or every name for an address")
+	fmt.Println("hosts import [file] [-force]                 --  Copies names from a hosts file or cjdroute.conf, the one")
+	fmt.Println("                                                  from -file by default, keeping names already set")
+	fmt.Println("dnscache list|flush                          --  Shows or empties the cache of resolved hostnames and")
+	fmt.Println("                                                  addresses")
+	fmt.Println("whereis                                      --  Shows where the admin address, password and config")
+	fmt.Println("                                                  file are being read from")
 	fmt.Println("cjdnsadmin <-file /path/to/cjdroute.conf>    --  Generates a .cjdnsadmin file in your home diectory")
 	fmt.Println("                                                  using the specified cjdroute.conf as input")
+	fmt.Println("cjdnsadmin -file <conf> -add-profile <name>  --  Adds the node to your .cjdnsadmin as a named profile,")
+	fmt.Println("                                                  which other commands can use with -node <name>")
 	fmt.Println("addpeer '<json peer details>'                --  Adds the peer details to your config file")
+	fmt.Println("addpeer --card <file>                        --  Adds the peer described by a peer card to your config")
+	fmt.Println("importpeers <dir> [-region] [-max]           --  Adds new peers from a directory of public peer files,")
+	fmt.Println("                                                  optionally with -resolve and -ping to check them")
 	fmt.Println("addpass [password]                           --  Adds the password to your config file, or generates")
 	fmt.Println("                                                  one and then adds that")
+	fmt.Println("peercard [password] [-name] [-contact]       --  Prints the details a new peer needs to connect to you")
+	fmt.Println("                                                  as JSON and a QR code, using the newest password")
+	fmt.Println("                                                  if none is given")
 	fmt.Println("cleanconfig [-file] [-outfile]               --  Strips all comments from the config file and saves")
 	fmt.Println("                                                  it at outfile")
+	fmt.Println("checkconfig [-file]                          --  Checks the config file for mistakes and insecure")
+	fmt.Println("                                                  settings, exiting with an error if any are found")
+	fmt.Println("migrateconfig [-to layout]                   --  Converts the config file to another cjdns config layout,")
+	fmt.Println("                                                  3 (the newest) by default. See the README for layouts")
+	fmt.Println("confdiff <file> <file>                       --  Shows the differences in peers, passwords and")
+	fmt.Println("                                                  settings between two config files")
+	fmt.Println("restoreconfig [n]                            --  Restores the config file from its nth most recent")
+	fmt.Println("                                                  backup, which defaults to 1")
+	fmt.Println("config get <path>                            --  Prints the config setting at path, for example")
+	fmt.Println("                                                  interfaces.UDPInterface[0].bind")
+	fmt.Println("config set <path> <JSON value>               --  Changes the config setting at path. Use [+] to append")
+	fmt.Println("                                                  to an array. Strings must be quoted, or use -string")
 	fmt.Println("log [-l level] [-logfile file] [-line]       --  Prints cjdns logs to stdout")
-	fmt.Println("passgen [prefix]                             --  Generates a random alphanumeric password between 15 and")
-	fmt.Println("                                                  50 characters. If you provide [prefix], it will be")
+	fmt.Println("    [-log-format short|full|json|logfmt]          using a built in format or a Go template")
+	fmt.Println("    [-include re] [-exclude re] [-ip addr]        and only showing matching messages. With -follow it")
+	fmt.Println("    [-follow] [-record dir]                       reconnects whenever cjdns restarts. -record saves every")
+	fmt.Println("                                                  message in dir as well and -syslog url forwards them")
+	fmt.Println("    [-summary 60s] [-top n]                       -summary counts messages for a while and prints the")
+	fmt.Println("                                                  most common levels, sources and messages instead")
+	fmt.Println("logreplay <file or dir...>                   --  Prints a log recorded with -record using the same")
+	fmt.Println("                                                  filters and formatting as log, or -summary")
+	fmt.Println("passgen [prefix] [-n] [-length] [-alphabet]  --  Generates random passwords, 32 alphanumeric characters")
+	fmt.Println("                                                  long by default. If you provide [prefix], it will be")
 	fmt.Println("                                                  prepended. This is to help you keep track of your")
 	fmt.Println("                                                  peering passwords")
 	fmt.Println("dump                                         --  Dumps the entire routing table to stdout")
 	fmt.Println("kill                                         --  Gracefully kills cjdns")
 	fmt.Println("memory                                       --  Returns the bytes of memory allocated by the router")
 	fmt.Println("")
+	fmt.Println("Hostnames are resolved with -resolver-mode system, mesh or both, using the DNS servers")
+	fmt.Println("given with -resolver or listed as resolvers in your .cjdnsadmin, or HypeDNS by default.")
+	fmt.Println("")
 	fmt.Println("Use `cjdcmd --help` for a list of flags.")
 	fmt.Println("")
 
 }
-
-// Returns a random alphanumeric string where length is <= max >= min
-func randString(min, max int) string {
-	r := myRand(min, max, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
-	return r
-}
-
-// Returns a random character from the specified string where length is <= max >= min
-func myRand(min, max int, char string) string {
-
-	var length int
-
-	if min < max {
-		length = min + rand.Intn(max-min)
-	} else {
-		length = min
-	}
-
-	buf := make([]byte, length)
-	for i := 0; i < length; i++ {
-		buf[i] = char[rand.Intn(len(char)-1)]
-	}
-	return string(buf)
-}
-
-func stripComments(b []byte) ([]byte, error) {
-	regComment, err := regexp.Compile("(?s)//.*?\n|/\\*.*?\\*/")
-	if err != nil {
-		return nil, err
-	}
-	out := regComment.ReplaceAllLiteral(b, nil)
-	return out, nil
-}
@@ -0,0 +1,48 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		in     []string
+		want   []string
+		region string
+		level  string
+	}{
+		{[]string{"peers/", "--region", "eu"}, []string{"peers/"}, "eu", ""},
+		{[]string{"--region", "eu", "peers/"}, []string{"peers/"}, "eu", ""},
+		{[]string{"/var/log/cjdns/", "-l", "WARN", "-region", "na"}, []string{"/var/log/cjdns/"}, "na", "WARN"},
+		{[]string{"set", "a.b", "-5"}, []string{"set", "a.b", "-5"}, "", ""},
+		{[]string{"-", "-l", "INFO"}, []string{"-"}, "", "INFO"},
+		{[]string{"a", "--", "-l", "b"}, []string{"a", "-l", "b"}, "", ""},
+		{[]string{"-l", "DEBUG", "--", "-region"}, []string{"-region"}, "", "DEBUG"},
+		{nil, nil, "", ""},
+	}
+	for _, test := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		region := fs.String("region", "", "")
+		level := fs.String("l", "", "")
+		got := parseArgs(fs, test.in)
+		if !reflect.DeepEqual(got, test.want) || *region != test.region || *level != test.level {
+			t.Errorf("parseArgs(%q) = %q region %q level %q, want %q region %q level %q",
+				test.in, got, *region, *level, test.want, test.region, test.level)
+		}
+	}
+}
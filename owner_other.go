@@ -0,0 +1,34 @@
+//go:build windows || plan9
+
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import "os"
+
+// Files have no owner and group here
+func fileOwner(fi os.FileInfo) (uid, gid int) {
+	return -1, -1
+}
+
+// Files have no owner and group to copy here
+func copyOwner(src os.FileInfo, dst string) error {
+	return nil
+}
+
+// There is no sudo here
+func sudoUser() (uid, gid int, home string, ok bool) {
+	return
+}
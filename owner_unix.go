@@ -0,0 +1,60 @@
+//go:build !windows && !plan9
+
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Returns the owner and group of a file, or -1 if they aren't known
+func fileOwner(fi os.FileInfo) (uid, gid int) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(st.Uid), int(st.Gid)
+}
+
+// Gives dst the same owner and group as the file described by src
+func copyOwner(src os.FileInfo, dst string) error {
+	uid, gid := fileOwner(src)
+	if uid < 0 {
+		return nil
+	}
+	return os.Chown(dst, uid, gid)
+}
+
+// Returns the user and group that ran cjdcmd with sudo, and their home
+// directory, if it is running as root under sudo
+func sudoUser() (uid, gid int, home string, ok bool) {
+	if os.Geteuid() != 0 {
+		return
+	}
+	uid, uerr := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, gerr := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if uerr != nil || gerr != nil {
+		return
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return
+	}
+	return uid, gid, u.HomeDir, true
+}
@@ -0,0 +1,160 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+)
+
+const (
+	alnumChars      = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	base32PassChars = "abcdefghijklmnopqrstuvwxyz234567"
+
+	defaultPassLength = 32 // characters
+	defaultPassWords  = 6  // words
+)
+
+// Returns a uniformly distributed random number in [0, n)
+func randInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// Returns a string of length random characters from chars
+func randChars(length int, chars string) (string, error) {
+	buf := make([]byte, length)
+	for i := range buf {
+		n, err := randInt(len(chars))
+		if err != nil {
+			return "", err
+		}
+		buf[i] = chars[n]
+	}
+	return string(buf), nil
+}
+
+// Returns count random words joined by dashes
+func randWords(count int, words []string) (string, error) {
+	out := make([]string, count)
+	for i := range out {
+		n, err := randInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		out[i] = words[n]
+	}
+	return strings.Join(out, "-"), nil
+}
+
+// Reads a word list with one word per line. Diceware lists, which start each
+// line with the dice rolls, are also understood.
+func readWordList(file string) (words []string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		word := fields[len(fields)-1]
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("%v does not contain enough words", file)
+	}
+	return
+}
+
+// Generates a password using the alphabet and length chosen with the
+// -alphabet, -length and -wordlist flags. Also returns the number of bits of
+// entropy it holds.
+func newPassword() (pass string, bits float64, err error) {
+	length := PassLength
+	switch PassAlphabet {
+	case "alnum", "base32":
+		chars := alnumChars
+		if PassAlphabet == "base32" {
+			chars = base32PassChars
+		}
+		if length <= 0 {
+			length = defaultPassLength
+		}
+		pass, err = randChars(length, chars)
+		bits = float64(length) * math.Log2(float64(len(chars)))
+
+	case "words", "diceware":
+		words := defaultWords
+		if PassWordList != "" {
+			if words, err = readWordList(PassWordList); err != nil {
+				return
+			}
+		}
+		if length <= 0 {
+			length = defaultPassWords
+		}
+		pass, err = randWords(length, words)
+		bits = float64(length) * math.Log2(float64(len(words)))
+
+	default:
+		err = fmt.Errorf("Unknown alphabet '%v', use alnum, base32 or words", PassAlphabet)
+	}
+	return
+}
+
+// Prints one or more new passwords, with an optional prefix to help keep
+// track of who they were given to
+func passGen(data []string) {
+	var prefix string
+	if len(data) > 0 && len(data[0]) > 0 {
+		prefix = data[0] + "_"
+	}
+	if PassCount < 1 {
+		PassCount = 1
+	}
+
+	var bits float64
+	for i := 0; i < PassCount; i++ {
+		pass, b, err := newPassword()
+		if err != nil {
+			fmt.Println("Unable to generate password:", err)
+			return
+		}
+		bits = b
+		fmt.Println(prefix + pass)
+	}
+
+	// Keep stdout clean for scripts
+	fmt.Fprintf(os.Stderr, "Entropy: %.0f bits per password\n", bits)
+}
@@ -0,0 +1,274 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"rsc.io/qr"
+	"strings"
+)
+
+// The newest peer card format we know how to read and write
+const peerCardVersion = 1
+
+// A peer card holds everything another node needs to peer with us
+type peerCard struct {
+	Version   int               `json:"version"`
+	PublicKey string            `json:"publicKey"`
+	IPv6      string            `json:"ipv6,omitempty"`
+	Password  string            `json:"password"`
+	Name      string            `json:"name,omitempty"`
+	Contact   string            `json:"contact,omitempty"`
+	Addresses []peerCardAddress `json:"addresses"`
+}
+
+// An address the node can be reached at, and which interface it belongs to
+type peerCardAddress struct {
+	Interface string `json:"interface"`
+	Address   string `json:"address"`
+}
+
+// Creates a peer card for the node described by conf without any addresses
+func newPeerCard(conf map[string]interface{}, password string) *peerCard {
+	card := &peerCard{
+		Version:  peerCardVersion,
+		Password: password,
+		Name:     CardName,
+		Contact:  CardContact,
+	}
+	card.PublicKey, _ = conf["publicKey"].(string)
+	card.IPv6, _ = conf["ipv6"].(string)
+	return card
+}
+
+// Adds every address the settings block for an interface can be reached at
+func (card *peerCard) addInterface(iface string, block map[string]interface{}) {
+	bind, _ := block["bind"].(string)
+	switch strings.ToLower(iface) {
+	case "udpinterface":
+		host, port, err := net.SplitHostPort(bind)
+		if err != nil {
+			fmt.Printf("Skipping %v with invalid bind address '%v'\n", iface, bind)
+			return
+		}
+		ip := net.ParseIP(host)
+		if ip != nil && !ip.IsUnspecified() {
+			if !ip.IsLoopback() {
+				card.Addresses = append(card.Addresses, peerCardAddress{iface, bind})
+			}
+			return
+		}
+		// Bound to every address, so list all the ones others could use
+		for _, addr := range localAddresses(ip != nil && ip.To4() == nil) {
+			card.Addresses = append(card.Addresses, peerCardAddress{iface, net.JoinHostPort(addr, port)})
+		}
+
+	case "ethinterface":
+		nic, err := net.InterfaceByName(bind)
+		if err != nil || len(nic.HardwareAddr) == 0 {
+			fmt.Printf("Unable to get the MAC address of '%v', skipping it\n", bind)
+			return
+		}
+		card.Addresses = append(card.Addresses, peerCardAddress{iface, nic.HardwareAddr.String()})
+	}
+}
+
+// Adds the addresses of every interface in conf
+func (card *peerCard) addAllInterfaces(conf map[string]interface{}) {
+	for _, name := range interfaceNames(conf) {
+		for _, block := range interfaceBlocks(conf, name) {
+			card.addInterface(name, block)
+		}
+	}
+}
+
+// Returns the connectTo entries described by the card, grouped by interface
+func (card *peerCard) connectTo() map[string]map[string]interface{} {
+	out := make(map[string]map[string]interface{})
+	for _, a := range card.Addresses {
+		peer := map[string]interface{}{
+			"password":  card.Password,
+			"publicKey": card.PublicKey,
+		}
+		if card.Name != "" {
+			peer["name"] = card.Name
+		}
+		if card.Contact != "" {
+			peer["contact"] = card.Contact
+		}
+		if out[a.Interface] == nil {
+			out[a.Interface] = make(map[string]interface{})
+		}
+		out[a.Interface][a.Address] = peer
+	}
+	return out
+}
+
+// Returns the addresses of this machine that other nodes could connect to,
+// leaving out loopback, link-local and cjdns addresses
+func localAddresses(ipv6 bool) (addrs []string) {
+	ifaddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return
+	}
+	for _, a := range ifaddrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || (ip.To4() == nil) != ipv6 {
+			continue
+		}
+		if ip.To4() == nil && ip[0] == 0xfc {
+			continue
+		}
+		addrs = append(addrs, ip.String())
+	}
+	return
+}
+
+// Reads a peer card from file, or from stdin if file is "-", and checks it
+func readPeerCard(file string) (card *peerCard, err error) {
+	var raw []byte
+	if file == "-" {
+		raw, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		raw, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	card = &peerCard{}
+	if err = parseJSON(file, raw, card); err != nil {
+		return nil, err
+	}
+	if card.Version == 0 {
+		return nil, fmt.Errorf("%v is not a peer card", file)
+	}
+	if card.Version > peerCardVersion {
+		return nil, fmt.Errorf("Peer card version %d is newer than this cjdcmd supports", card.Version)
+	}
+	if err = validPublicKey(card.PublicKey); err != nil {
+		return nil, err
+	}
+	if card.Password == "" {
+		return nil, fmt.Errorf("Peer card has no password")
+	}
+	for _, a := range card.Addresses {
+		if err = validPeerAddress(a.Interface, a.Address); err != nil {
+			return nil, fmt.Errorf("Invalid %v address '%v': %v", a.Interface, a.Address, err)
+		}
+	}
+	return
+}
+
+// Prints the card as JSON, followed by a QR code of it when printing to a
+// terminal
+func printPeerCard(card *peerCard) {
+	out, err := json.MarshalIndent(card, "", "\t")
+	if err != nil {
+		fmt.Println("Unable to create peer card:", err)
+		return
+	}
+	fmt.Println(string(out))
+
+	if !isTerminal(os.Stdout) {
+		return
+	}
+	compact, _ := json.Marshal(card)
+	if err := printQR(string(compact)); err != nil {
+		fmt.Println("Unable to create QR code:", err)
+	}
+}
+
+// Draws text as a QR code using block characters, two rows per line
+func printQR(text string) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return err
+	}
+
+	const quiet = 2
+	black := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return false
+		}
+		return code.Black(x, y)
+	}
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		var line []string
+		for x := -quiet; x < code.Size+quiet; x++ {
+			// Draw the white modules, as most terminals have a dark background
+			top, bottom := black(x, y), black(x, y+1)
+			switch {
+			case !top && !bottom:
+				line = append(line, "█")
+			case !top:
+				line = append(line, "▀")
+			case !bottom:
+				line = append(line, "▄")
+			default:
+				line = append(line, " ")
+			}
+		}
+		fmt.Println(strings.Join(line, ""))
+	}
+	return nil
+}
+
+// Prints a peer card for one of the passwords in the config file, or the
+// most recently added one if none was given
+func showPeerCard(data []string) {
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	conf, err := loadExtConfig(File)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return
+	}
+
+	passwords, _ := conf["authorizedPasswords"].([]interface{})
+	var password string
+	for _, p := range passwords {
+		entry, _ := p.(map[string]interface{})
+		pass, _ := entry["password"].(string)
+		if len(data) == 0 || pass == data[0] {
+			password = pass
+		}
+	}
+	if password == "" {
+		if len(data) > 0 {
+			fmt.Println("That password is not in your config, add it with addpass first")
+		} else {
+			fmt.Println("Your config does not contain any passwords, add one with addpass first")
+		}
+		return
+	}
+
+	card := newPeerCard(conf, password)
+	card.addAllInterfaces(conf)
+	if len(card.Addresses) == 0 {
+		fmt.Println("None of your interfaces have an address other nodes can reach")
+		return
+	}
+	printPeerCard(card)
+}
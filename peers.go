@@ -128,8 +128,14 @@ func doPeers(user *admin.Conn, target Target) {
 		}
 	}
 
+	ips := make([]string, len(output))
+	for i, node := range output {
+		ips[i] = node.IP.String()
+	}
+	names := resolveIPs(ips)
+
 	for _, node := range output {
-		hostname, _ := resolveIP(node.IP.String())
+		hostname := names[node.IP.String()]
 		tText := node.IP.String()
 		if hostname != "" {
 			tText += " (" + hostname + ")"
@@ -150,10 +156,16 @@ func doOwnPeers(user *admin.Conn) {
 		fmt.Println(err)
 		return
 	}
+	ips := make([]string, len(peers))
+	for i, node := range peers {
+		ips[i] = node.PublicKey.IP().String()
+	}
+	names := resolveIPs(ips)
+
 	for _, node := range peers {
 		key := node.PublicKey
 
-		hostname, _ := resolveIP(key.IP().String())
+		hostname := names[key.IP().String()]
 		tText := key.IP().String()
 		if hostname != "" {
 			tText += " (" + hostname + ")"
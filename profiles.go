@@ -0,0 +1,133 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/inhies/go-cjdns/admin"
+	"sort"
+	"strings"
+)
+
+// The contents of a .cjdnsadmin file. Older files describe a single node at
+// the top level while newer ones hold named profiles for several. Resolvers
+// lists the DNS servers to use for cjdns names.
+type cjdnsadminFile struct {
+	admin.CjdnsAdminConfig
+	Profiles  map[string]*admin.CjdnsAdminConfig `json:"profiles,omitempty"`
+	Default   string                             `json:"default,omitempty"`
+	Resolvers []string                           `json:"resolvers,omitempty"`
+}
+
+// Returns true if the file describes a node at the top level
+func (f *cjdnsadminFile) hasSingleNode() bool {
+	return f.Addr != "" || f.Port != 0 || f.Password != ""
+}
+
+// Picks the node to use: the profile named by -node, then the default
+// profile, then the only profile, and finally the single node described by
+// older files
+func (f *cjdnsadminFile) node(file string) (*admin.CjdnsAdminConfig, error) {
+	name := Node
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" && len(f.Profiles) == 1 && !f.hasSingleNode() {
+		for only := range f.Profiles {
+			name = only
+		}
+	}
+	if name == "" {
+		if len(f.Profiles) > 0 && !f.hasSingleNode() {
+			return nil, fmt.Errorf("%v has several profiles and no default, choose one with --node: %v",
+				file, strings.Join(f.profileNames(), ", "))
+		}
+		return &f.CjdnsAdminConfig, nil
+	}
+
+	node, ok := f.Profiles[name]
+	if !ok || node == nil {
+		return nil, fmt.Errorf("There is no profile named '%v' in %v", name, file)
+	}
+	return node, nil
+}
+
+// Returns the names of the profiles, sorted
+func (f *cjdnsadminFile) profileNames() (names []string) {
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Adds or replaces a named profile in a .cjdnsadmin file, keeping the nodes
+// already in it. A node described by an older file is moved in to its own
+// profile first.
+func addCjdnsadminProfile(file, name string, node *admin.CjdnsAdminConfig) {
+	f := new(cjdnsadminFile)
+	if fileExists(file) {
+		if err := readJSONFile(file, f); err != nil {
+			fmt.Println("Error loading cjdnsadmin file:", err)
+			return
+		}
+	}
+	if f.Profiles == nil {
+		f.Profiles = make(map[string]*admin.CjdnsAdminConfig)
+	}
+
+	if f.hasSingleNode() {
+		oldName := "default"
+		for i := 2; f.Profiles[oldName] != nil || oldName == name; i++ {
+			oldName = fmt.Sprintf("default%d", i)
+		}
+		old := f.CjdnsAdminConfig
+		f.Profiles[oldName] = &old
+		f.CjdnsAdminConfig = admin.CjdnsAdminConfig{}
+		if f.Default == "" {
+			f.Default = oldName
+		}
+		fmt.Printf("Moved the node already in %v to profile '%v'\n", file, oldName)
+	}
+
+	if _, ok := f.Profiles[name]; ok {
+		fmt.Printf("Replace profile '%v' in %v? [y/N]: ", name, file)
+		if !gotYes(false) {
+			return
+		}
+	}
+	f.Profiles[name] = node
+	if f.Default == "" {
+		f.Default = name
+	}
+
+	out := map[string]interface{}{
+		"profiles": f.Profiles,
+		"default":  f.Default,
+	}
+	if len(f.Resolvers) > 0 {
+		out["resolvers"] = f.Resolvers
+	}
+	jsonout, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		fmt.Println("Unable to create JSON for .cjdnsadmin")
+		return
+	}
+	if err := writeFileAtomic(file, jsonout, 0600); err != nil {
+		fmt.Println("Error saving .cjdnsadmin:", err)
+		return
+	}
+	fmt.Printf("Saved profile '%v' to %v\n", name, file)
+}
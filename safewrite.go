@@ -0,0 +1,335 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/inhies/go-cjdns/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Saves conf to file, keeping backups of the previous versions and making
+// sure a crash can never leave a partially written file behind
+func saveConfig(file string, conf map[string]interface{}, mode os.FileMode) error {
+	return replaceFile(file, mode, func(tmp string) error {
+		return config.SaveConfig(tmp, conf, mode)
+	})
+}
+
+// Saves conf to OutFile, or File if no OutFile was given, after showing the
+// changes and asking the user to confirm. Returns true if it was saved.
+func writeConfig(conf map[string]interface{}) bool {
+	// Get the permissions from the input file
+	stats, err := os.Stat(File)
+	if err != nil {
+		fmt.Println("Error getting permissions for original file:", err)
+		return false
+	}
+
+	if File != "" && OutFile == "" {
+		OutFile = File
+	}
+
+	// Show what will change and prompt before overwriting
+	if !confirmOverwrite(conf) {
+		return false
+	}
+
+	fmt.Printf("Saving configuration to: %v... ", OutFile)
+	err = saveConfig(OutFile, conf, stats.Mode())
+	if err != nil {
+		fmt.Println("\nError saving config:", err)
+		return false
+	}
+	fmt.Printf("Saved\n")
+	return true
+}
+
+// Returns the file a path points to after following any symlinks, or the
+// path itself if it doesn't exist yet
+func realPath(file string) string {
+	if real, err := filepath.EvalSymlinks(file); err == nil {
+		return real
+	}
+	return file
+}
+
+// Locks the config file being edited, and the file it will be saved to if
+// --outfile names a different one. They are locked in a fixed order so that
+// two cjdcmds can never each wait for the other.
+func lockConfigEdit() (unlock func(), err error) {
+	files := []string{realPath(File)}
+	if OutFile != "" && realPath(OutFile) != files[0] {
+		files = append(files, realPath(OutFile))
+		sort.Strings(files)
+	}
+
+	var unlocks []func()
+	unlock = func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, file := range files {
+		u, err := lockConfig(file)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		unlocks = append(unlocks, u)
+	}
+	return unlock, nil
+}
+
+// Atomically replaces file with the output of write, which is given the name
+// of a temporary file in the same directory to write to. The current file is
+// rotated in to the backups first. If file is a symlink the file it points to
+// is replaced, and the new file keeps the old one's owner and group.
+func replaceFile(file string, mode os.FileMode, write func(tmp string) error) (err error) {
+	file = realPath(file)
+	orig, statErr := os.Stat(file)
+
+	dir, base := filepath.Split(file)
+	tmp, err := ioutil.TempFile(dir, "."+base+".")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	tmp.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if err = write(tmpName); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, mode); err != nil {
+		return err
+	}
+	if statErr == nil {
+		if err := copyOwner(orig, tmpName); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to keep the owner and group of %v: %v\n", file, err)
+		}
+	}
+	if err = syncFile(tmpName); err != nil {
+		return err
+	}
+
+	if fileExists(file) {
+		if err = rotateBackups(file); err != nil {
+			return fmt.Errorf("Unable to back up %v: %v", file, err)
+		}
+	}
+	if err = os.Rename(tmpName, file); err != nil {
+		return err
+	}
+
+	// Make sure the rename itself is on disk
+	if d, err := os.Open(filepath.Dir(file)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Writes a file that isn't worth keeping backups of, replacing it in one step
+// so readers never see it half written. It keeps the owner of the file it
+// replaces, and a new file is given to the user who ran sudo.
+func writeFileAtomic(file string, b []byte, mode os.FileMode) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		var oerr error
+		if orig, serr := os.Stat(file); serr == nil {
+			oerr = copyOwner(orig, tmp.Name())
+		} else {
+			oerr = chownSudoUser(tmp.Name())
+		}
+		if oerr != nil {
+			fmt.Fprintf(os.Stderr, "Unable to set the owner of %v: %v\n", file, oerr)
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Creates a directory and any missing parents, giving the ones it creates to
+// the user who ran sudo, as chownSudoUser does
+func mkdirAllUser(dir string, perm os.FileMode) error {
+	var created []string
+	for d := dir; !fileExists(d); d = filepath.Dir(d) {
+		created = append(created, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	for _, d := range created {
+		if err := chownSudoUser(d); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to set the owner of %v: %v\n", d, err)
+		}
+	}
+	return nil
+}
+
+// Gives a file or directory cjdcmd created to the user who ran it with sudo,
+// if it is in their home directory, so they don't need sudo to change it
+func chownSudoUser(path string) error {
+	uid, gid, home, ok := sudoUser()
+	if !ok || !strings.HasPrefix(path, filepath.Clean(home)+string(filepath.Separator)) {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// Flushes a file to disk
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Returns the name of the nth backup of file
+func backupName(file string, n int) string {
+	return file + "." + strconv.Itoa(n)
+}
+
+// Shifts file.1 to file.2 and so on, dropping the oldest, then copies file
+// to file.1
+func rotateBackups(file string) error {
+	if ConfigBackups <= 0 {
+		return nil
+	}
+	os.Remove(backupName(file, ConfigBackups))
+	for n := ConfigBackups - 1; n >= 1; n-- {
+		if fileExists(backupName(file, n)) {
+			if err := os.Rename(backupName(file, n), backupName(file, n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return copyFile(file, backupName(file, 1))
+}
+
+// Copies src to dst, keeping its permissions
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stats, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stats.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Replaces the config file with one of its backups. The current file becomes
+// the newest backup so the restore can be undone.
+func restoreConfig(data []string) {
+	n := 1
+	if len(data) > 0 {
+		var err error
+		n, err = strconv.Atoi(data[0])
+		if err != nil || n < 1 {
+			fmt.Println("Invalid backup number", data[0])
+			return
+		}
+	}
+
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	unlock, err := lockConfigEdit()
+	if err != nil {
+		fmt.Println("Unable to lock config:", err)
+		return
+	}
+	defer unlock()
+
+	// Backups are made next to the file a symlink points to
+	backup := backupName(realPath(File), n)
+	raw, err := ioutil.ReadFile(backup)
+	if err != nil {
+		fmt.Println("Unable to read backup:", err)
+		return
+	}
+	conf, err := loadExtConfig(backup)
+	if err != nil {
+		fmt.Println("Error loading backup:", err)
+		return
+	}
+	stats, err := os.Stat(backup)
+	if err != nil {
+		fmt.Println("Error getting permissions for backup:", err)
+		return
+	}
+
+	OutFile = File
+	if !confirmOverwrite(conf) {
+		return
+	}
+
+	fmt.Printf("Restoring %v from %v... ", File, backup)
+	err = replaceFile(File, stats.Mode(), func(tmp string) error {
+		return ioutil.WriteFile(tmp, raw, stats.Mode())
+	})
+	if err != nil {
+		fmt.Println("\nError restoring config:", err)
+		return
+	}
+	fmt.Printf("Restored\n")
+}
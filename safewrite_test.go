@@ -0,0 +1,160 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Sets the number of backups to keep for the rest of a test
+func setConfigBackups(t *testing.T, n int) {
+	old := ConfigBackups
+	ConfigBackups = n
+	t.Cleanup(func() { ConfigBackups = old })
+}
+
+func TestReplaceFileFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real.conf")
+	link := filepath.Join(dir, "cjdroute.conf")
+	if err := ioutil.WriteFile(real, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(real, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	setConfigBackups(t, 1)
+	err := replaceFile(link, 0600, func(tmp string) error {
+		return ioutil.WriteFile(tmp, []byte("new"), 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%v is no longer a symlink", link)
+	}
+	for file, want := range map[string]string{real: "new", real + ".1": "old"} {
+		if b, _ := ioutil.ReadFile(file); string(b) != want {
+			t.Errorf("%v holds %q, want %q", file, b, want)
+		}
+	}
+}
+
+func TestRestoreConfigThroughSymlink(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real.conf")
+	link := filepath.Join(dir, "cjdroute.conf")
+	if err := ioutil.WriteFile(real, []byte(`{"a": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(real, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	setConfigBackups(t, 2)
+	err := replaceFile(link, 0600, func(tmp string) error {
+		return ioutil.WriteFile(tmp, []byte(`{"a": 2}`), 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Answer yes when asked to overwrite
+	answer := filepath.Join(dir, "answer")
+	if err := ioutil.WriteFile(answer, []byte("y\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	oldStdin := os.Stdin
+	os.Stdin = stdin
+	File, OutFile = link, ""
+	defer func() { os.Stdin, File, OutFile = oldStdin, "", "" }()
+
+	restoreConfig(nil)
+
+	if b, _ := ioutil.ReadFile(real); string(b) != `{"a": 1}` {
+		t.Errorf("%v holds %q after restoring, want the backup", real, b)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%v is no longer a symlink", link)
+	}
+}
+
+func TestLockConfigEdit(t *testing.T) {
+	dir := t.TempDir()
+	File = filepath.Join(dir, "cjdroute.conf")
+	OutFile = filepath.Join(dir, "out.conf")
+	defer func() { File, OutFile = "", "" }()
+
+	unlock, err := lockConfigEdit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{File, OutFile} {
+		if !fileExists(file + ".lock") {
+			t.Errorf("%v was not locked", file)
+		}
+	}
+	unlock()
+	for _, file := range []string{File, OutFile} {
+		if fileExists(file + ".lock") {
+			t.Errorf("%v.lock was left behind", file)
+		}
+	}
+
+	// The same file given twice must only be locked once
+	OutFile = File
+	unlock, err = lockConfigEdit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestReplaceFileKeepsOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+	file := filepath.Join(t.TempDir(), "cjdroute.conf")
+	if err := ioutil.WriteFile(file, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(file, 1234, 5678); err != nil {
+		t.Skip("unable to change owner:", err)
+	}
+
+	setConfigBackups(t, 0)
+	err := replaceFile(file, 0600, func(tmp string) error {
+		return ioutil.WriteFile(tmp, []byte("new"), 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := fileOwner(fi); uid != 1234 || gid != 5678 {
+		t.Errorf("owner is %v:%v, want 1234:5678", uid, gid)
+	}
+}
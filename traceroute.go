@@ -72,7 +72,11 @@ func doTraceroute(user *admin.Conn, target Target) {
 
 	fmt.Println("Finding all routes to", tText)
 
-	count := 0
+	// Find every route first so the hostnames along all of them can be
+	// looked up together
+	var targets []*admin.Route
+	var routes []admin.Routes
+	var ips []string
 	for i := range table {
 		if usingPath {
 			if table[i].Path.String() != target.Supplied {
@@ -89,20 +93,21 @@ func doTraceroute(user *admin.Conn, target Target) {
 		}
 
 		response := table.Hops(*table[i].Path)
-		if err != nil {
-			fmt.Println("Error:", err)
-		}
 		response.SortByPath()
+		targets = append(targets, table[i])
+		routes = append(routes, response)
+		for _, p := range response {
+			ips = append(ips, p.IP.String())
+		}
+	}
+	names := resolveIPs(ips)
+
+	count := 0
+	for i, response := range routes {
 		count++
-		fmt.Printf("\nRoute #%d to target: %v\n", count, table[i].Path)
+		fmt.Printf("\nRoute #%d to target: %v\n", count, targets[i].Path)
 		for y, p := range response {
-			hostname, _ := resolveIP(p.IP.String())
-			var IP string
-			if hostname != "" {
-				IP = p.IP.String() + " (" + hostname + ")"
-			} else {
-				IP = p.IP.String()
-			}
+			IP := withHostname(p.IP.String(), names)
 			fmt.Printf("IP: %v -- Version: %d -- Path: %s -- Link: %s -- Time:", IP, p.Version, p.Path, p.Link)
 			if y == 0 {
 				fmt.Printf(" Skipping ourself\n")
@@ -0,0 +1,76 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Prints the public key and address belonging to a hex encoded private key.
+// Returns false if the key is invalid.
+func privateToIP(privStr string) bool {
+	priv, err := decodePrivateKey(privStr)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	pub, err := privateToPublic(priv)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	if pub.IP()[0] != 0xfc {
+		fmt.Println("Warning: this private key does not produce a valid cjdns address")
+	}
+	printIdentity(nil, pub)
+	return true
+}
+
+// Prints the public key and IPv6 address derived from the privateKey in the
+// config file, then checks that the config's publicKey and ipv6 are present
+// and agree with them. Exits with a non-zero status if they do not.
+func whoami() {
+	if err := setConfigFile(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	conf, err := loadExtConfig(File)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		os.Exit(1)
+	}
+
+	c := &configChecker{}
+	// Problems with privateKey itself are reported by checkIdentity
+	if privStr, _ := conf["privateKey"].(string); privStr != "" {
+		if _, err := decodePrivateKey(privStr); err == nil {
+			privateToIP(privStr)
+		}
+	}
+	for _, k := range []string{"publicKey", "ipv6"} {
+		if v, _ := conf[k].(string); v == "" {
+			c.errorf("No %v found", k)
+		}
+	}
+	c.checkIdentity(conf)
+	if len(c.Problems) == 0 {
+		fmt.Println("privateKey, publicKey and ipv6 match")
+		return
+	}
+	for _, p := range c.Problems {
+		fmt.Printf("%-7v  %v\n", p.Severity, p.Msg)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,48 @@
+/*
+ * You may redistribute this program and/or modify it under the terms of
+ * the GNU General Public License as published by the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+// Words used to build passphrases when no word list file is given. There are
+// 256 of them, so each word adds 8 bits of entropy.
+var defaultWords = []string{
+	"able", "acid", "aged", "also", "area", "army", "away", "baby", "back",
+	"ball", "band", "bank", "base", "bath", "bear", "beat", "been", "beer",
+	"bell", "belt", "best", "bird", "blow", "blue", "boat", "body", "bomb",
+	"bond", "bone", "book", "boom", "born", "boss", "both", "bowl", "bulk",
+	"burn", "bush", "busy", "cafe", "cake", "calm", "came", "camp", "card",
+	"care", "cart", "case", "cash", "cast", "cell", "chat", "chef", "chip",
+	"city", "clay", "club", "coal", "coat", "code", "cold", "cook", "cool",
+	"cope", "copy", "cord", "core", "corn", "cost", "crew", "crop", "dark",
+	"data", "date", "dawn", "deal", "dear", "debt", "deck", "deep", "deer",
+	"desk", "dial", "diet", "disc", "dish", "dock", "door", "dose", "down",
+	"draw", "drop", "drum", "dual", "duck", "dust", "duty", "each", "earn",
+	"east", "easy", "edge", "else", "even", "ever", "exam", "exit", "face",
+	"fact", "fair", "fall", "farm", "fast", "fear", "feed", "feel", "file",
+	"fill", "film", "find", "fine", "fire", "firm", "fish", "five", "flag",
+	"flat", "flow", "folk", "food", "foot", "fork", "form", "fort", "four",
+	"free", "frog", "fuel", "full", "fund", "gain", "game", "gate", "gear",
+	"gift", "girl", "glad", "goal", "gold", "golf", "good", "grab", "gray",
+	"grew", "grid", "grow", "gulf", "hair", "half", "hall", "hand", "hang",
+	"hard", "harm", "hawk", "head", "heat", "held", "help", "herb", "hero",
+	"hide", "high", "hill", "hint", "hold", "hole", "home", "hook", "hope",
+	"horn", "host", "hour", "huge", "hunt", "idea", "inch", "iron", "item",
+	"jazz", "join", "joke", "jump", "jury", "keen", "keep", "kept", "kick",
+	"kind", "king", "kiss", "kite", "knee", "knew", "knot", "lace", "lady",
+	"lake", "lamp", "land", "lane", "last", "late", "lawn", "lead", "leaf",
+	"lean", "left", "lens", "life", "lift", "like", "lime", "line", "link",
+	"lion", "list", "live", "load", "loan", "lock", "loft", "long", "look",
+	"loop", "lord", "loud", "love", "luck", "lung", "made", "mail", "main",
+	"make", "mall", "many", "maps", "mark", "mask", "mass", "meal", "meat",
+	"meet", "melt", "menu", "mild",
+}